@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/mailer"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/validator"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// Number of accounts purged in each run of the purge job
+const accountPurgeBatchSize = 100
+
+// Either the password or a second factor code confirms the deletion
+type deleteAccountDTO struct {
+	Password string `validate:"max=50"`
+	Code     string `validate:"max=11"` // A TOTP code or a recovery code
+}
+
+// Accounts created by signing in with a provider have a random password, they can set one through the reset flow
+var errReauthenticationRequired = internal.DefaultError{
+	Code:    "REAUTHENTICATION_REQUIRED",
+	Message: "Confirm with your password or a two-factor authentication code. If you signed up with a provider and never set a password, set one with \"Forgot password\" first",
+}
+
+// DeleteAccount schedules the deletion of the user's account and logs them out everywhere.
+// Logging in again before the grace period ends cancels the deletion.
+func (s *Server) DeleteAccount(c *fiber.Ctx) error {
+	err := internal.VerifyContentType(c, "application/x-www-form-urlencoded")
+	if err != nil {
+		return internal.ClientError(c, http.StatusUnsupportedMediaType, internal.DefaultError{
+			Code:    "UNSUPPORTED_MEDIA_TYPE",
+			Message: "Content-Type header must be application/x-www-form-urlencoded",
+		})
+	}
+
+	var deleteDTO deleteAccountDTO
+	err = c.BodyParser(&deleteDTO)
+	if err != nil {
+		return err
+	}
+
+	result, err := validator.Validate(deleteDTO)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to validate request body")
+	}
+
+	if !result.IsValid {
+		return result.SendValidationError(c)
+	}
+
+	userID := c.Locals("userID").(string)
+
+	ok, err := s.confirmDeletion(c, userID, deleteDTO)
+	if !ok {
+		return err
+	}
+
+	user, err := s.Users.FetchUser(userID)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to fetch user data")
+	}
+
+	scheduledFor, err := s.AccountDeletions.ScheduleDeletion(userID)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to schedule account deletion")
+	}
+
+	err = s.Sessions.DeleteAllSessions(userID)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to end user sessions")
+	}
+
+	s.Websocket.CloseUserSessions(userID)
+
+	go func() {
+		subject, body := mailer.AccountDeletionEmail(scheduledFor)
+
+		err := s.Mailer.Send(user.Email, subject, body)
+		if err != nil {
+			log.Errorf("Failed to send account deletion email: %v", err)
+		}
+	}()
+
+	return c.JSON(map[string]any{
+		"msg":          "Account scheduled for deletion, log in before the date to cancel it",
+		"scheduledFor": scheduledFor,
+	})
+}
+
+// confirmDeletion checks the password or the second factor code the user confirmed the deletion with,
+// responding with an error if neither is valid. Both go through the login throttle so they can't be guessed.
+func (s *Server) confirmDeletion(c *fiber.Ctx, userID string, deleteDTO deleteAccountDTO) (bool, error) {
+	switch {
+	case deleteDTO.Code != "":
+		valid, ok, err := s.checkSecondFactor(c, userID, deleteDTO.Code)
+		if !ok {
+			return false, err
+		}
+
+		if !valid {
+			return false, internal.ClientError(c, http.StatusBadRequest, errInvalidMFACode)
+		}
+	case deleteDTO.Password != "":
+		user, err := s.Users.FetchUser(userID)
+		if err != nil {
+			return false, internal.ServerError(c, err, "Failed to verify password")
+		}
+
+		attemptID, ok, err := s.startLoginAttempt(c, user.Email)
+		if !ok {
+			return false, err
+		}
+
+		err = s.Users.VerifyPassword(userID, deleteDTO.Password)
+		if err != nil && !errors.Is(err, models.ErrInvalidCredentials) {
+			return false, internal.ServerError(c, err, "Failed to verify password")
+		}
+
+		s.finishLoginAttempt(attemptID, userID, err == nil)
+
+		if err != nil {
+			return false, internal.ClientError(c, http.StatusUnauthorized, internal.DefaultError{
+				Code:    "INVALID_CREDENTIALS",
+				Message: "Password is incorrect. If you signed up with a provider and never set a password, set one with \"Forgot password\" first",
+			})
+		}
+	default:
+		return false, internal.ClientError(c, http.StatusBadRequest, errReauthenticationRequired)
+	}
+
+	return true, nil
+}
+
+// PurgeDeletedAccounts permanently deletes the accounts whose grace period is over,
+// checking every interval until the context is cancelled.
+func (s *Server) PurgeDeletedAccounts(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.purgeDueAccounts()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) purgeDueAccounts() {
+	userIDs, err := s.AccountDeletions.DueDeletions(accountPurgeBatchSize)
+	if err != nil {
+		log.Errorf("Failed to fetch accounts to delete: %v", err)
+		return
+	}
+
+	for _, userID := range userIDs {
+		purged, err := s.AccountDeletions.PurgeUser(userID)
+		if err != nil {
+			log.Errorf("Failed to delete account %s: %v", userID, err)
+			continue
+		}
+
+		// Skipped because the user logged in or another instance is purging them
+		if !purged {
+			continue
+		}
+
+		s.Websocket.CloseUserSessions(userID)
+		log.Infof("Deleted account %s", userID)
+	}
+}
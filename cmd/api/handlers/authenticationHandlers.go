@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
@@ -11,6 +13,7 @@ import (
 	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
 	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/validator"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
 )
 
 type registerUserDTO struct {
@@ -82,34 +85,9 @@ func (s *Server) Register(c *fiber.Ctx) error {
 		return err
 	}
 
-	// Generate access and refresh tokens
-	session, err := s.Sessions.NewSession(userID, registeruserDTO.Platform, registeruserDTO.OS, c.IP())
-	if err != nil {
-		return internal.ServerError(c, err, "Failed to establish user session")
-	}
-
-	accessToken, err := jwt.GenerateAccessToken(userID, session.SessionID)
-	if err != nil {
-		fmt.Println(err)
-		return internal.ServerError(c, err, "Failed to generate access token")
-	}
-
-	user, err := s.Users.FetchUser(userID)
-	if err != nil {
-		return internal.ServerError(c, err, "Failed to fetch user data")
-	}
+	s.queueVerificationEmail(userID, registeruserDTO.Email)
 
-	return c.JSON(map[string]any{
-		"msg":       "user created successfully",
-		"sessionID": session.SessionID,
-		"tokens": map[string]string{
-			"accessToken":  accessToken,
-			"refreshToken": session.RefreshToken,
-		},
-		"tokenType": "Bearer",
-		"expiresIn": jwt.AccessTokenExpirationDelta / time.Millisecond,
-		"user":      user,
-	})
+	return s.issueSession(c, userID, registeruserDTO.Platform, registeruserDTO.OS, "user created successfully")
 }
 
 func (s *Server) Login(c *fiber.Ctx) error {
@@ -136,9 +114,16 @@ func (s *Server) Login(c *fiber.Ctx) error {
 		return result.SendValidationError(c)
 	}
 
+	attemptID, ok, err := s.startLoginAttempt(c, loginuserDTO.Username)
+	if !ok {
+		return err
+	}
+
 	userID, err := s.Users.Authenticate(loginuserDTO.Username, loginuserDTO.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrInvalidCredentials) {
+			s.finishLoginAttempt(attemptID, userID, false)
+
 			return internal.ClientError(c, http.StatusUnauthorized, internal.DefaultError{
 				Code:    "INVALID_CREDENTIALS",
 				Message: "email or password is incorrect",
@@ -148,12 +133,114 @@ func (s *Server) Login(c *fiber.Ctx) error {
 		return internal.ServerError(c, err, "Failed to authenticate user")
 	}
 
-	session, err := s.Sessions.NewSession(userID, loginuserDTO.Platform, loginuserDTO.OS, c.IP())
+	mfaEnabled, err := s.MFA.IsEnabled(userID)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to authenticate user")
+	}
+
+	// With two-factor authentication the attempt stays a failure, the login only succeeds
+	// once the second factor is verified through /auth/mfa/verify
+	if mfaEnabled {
+		s.finishLoginAttempt(attemptID, userID, false)
+		return s.sendMFAChallenge(c, userID, loginuserDTO.Platform, loginuserDTO.OS)
+	}
+
+	s.finishLoginAttempt(attemptID, userID, true)
+
+	return s.issueSession(c, userID, loginuserDTO.Platform, loginuserDTO.OS, "login successful")
+}
+
+// completeLogin finishes a login once the user proved who they are,
+// asking for the second factor first if the user has one enabled.
+func (s *Server) completeLogin(c *fiber.Ctx, userID, platform, os string) error {
+	mfaEnabled, err := s.MFA.IsEnabled(userID)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to authenticate user")
+	}
+
+	// The session is only created once the second factor is verified through /auth/mfa/verify
+	if mfaEnabled {
+		return s.sendMFAChallenge(c, userID, platform, os)
+	}
+
+	return s.issueSession(c, userID, platform, os, "login successful")
+}
+
+// startLoginAttempt starts a throttled login attempt for the email, responding with an error if the account
+// or the client have to wait. Attempts count as failures until finishLoginAttempt says otherwise.
+func (s *Server) startLoginAttempt(c *fiber.Ctx, email string) (string, bool, error) {
+	attemptID, accountWait, clientWait, err := s.LoginThrottle.Start(email, c.IP())
+	if err != nil {
+		return "", false, internal.ServerError(c, err, "Failed to authenticate user")
+	}
+
+	// Checked before the credentials so a locked account doesn't reveal whether a guess was right
+	if accountWait > 0 {
+		return "", false, sendRetryAfter(c, accountWait, internal.DefaultError{
+			Code:    "ACCOUNT_LOCKED",
+			Message: "Too many failed login attempts, the account is temporarily locked",
+		})
+	}
+
+	if clientWait > 0 {
+		return "", false, sendRetryAfter(c, clientWait, internal.DefaultError{
+			Code:    "TOO_MANY_REQUESTS",
+			Message: "Too many failed login attempts, please try again later",
+		})
+	}
+
+	return attemptID, true, nil
+}
+
+func (s *Server) finishLoginAttempt(attemptID, userID string, succeeded bool) {
+	err := s.LoginThrottle.Finish(attemptID, userID, succeeded)
+	if err != nil {
+		log.Errorf("Failed to record login attempt: %v", err)
+	}
+}
+
+// PruneLoginAttempts deletes login attempts the throttle no longer looks at,
+// checking every interval until the context is cancelled.
+func (s *Server) PruneLoginAttempts(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := s.LoginThrottle.Prune()
+		if err != nil {
+			log.Errorf("Failed to delete old login attempts: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendRetryAfter rejects a throttled request, telling the client when it can try again.
+func sendRetryAfter(c *fiber.Ctx, wait time.Duration, clientError internal.DefaultError) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+
+	return internal.ClientError(c, http.StatusTooManyRequests, clientError)
+}
+
+// issueSession creates a new session for the user and responds with its tokens and the user's data.
+// Every way of logging in must go through it so clients always get the same response.
+func (s *Server) issueSession(c *fiber.Ctx, userID, platform, os, msg string) error {
+	// Logging in during the grace period keeps the account
+	deletionCancelled, err := s.AccountDeletions.CancelDeletion(userID)
 	if err != nil {
 		return internal.ServerError(c, err, "Failed to establish user session")
 	}
 
-	accessToken, err := jwt.GenerateAccessToken(userID, session.SessionID)
+	session, err := s.Sessions.NewSession(userID, platform, os, c.IP())
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to establish user session")
+	}
+
+	accessToken, err := s.Keys.GenerateAccessToken(userID, session.SessionID)
 	if err != nil {
 		return internal.ServerError(c, err, "Failed to generate access token")
 	}
@@ -163,8 +250,8 @@ func (s *Server) Login(c *fiber.Ctx) error {
 		return internal.ServerError(c, err, "Failed to fetch user data")
 	}
 
-	return c.JSON(map[string]any{
-		"msg":       "login successful",
+	response := map[string]any{
+		"msg":       msg,
 		"sessionID": session.SessionID,
 		"tokens": map[string]string{
 			"accessToken":  accessToken,
@@ -173,11 +260,19 @@ func (s *Server) Login(c *fiber.Ctx) error {
 		"tokenType": "Bearer",
 		"expiresIn": jwt.AccessTokenExpirationDelta / time.Millisecond,
 		"user":      user,
-	})
+	}
+
+	if deletionCancelled {
+		response["deletionCancelled"] = true
+	}
+
+	return c.JSON(response)
 }
 
 func (s *Server) Logout(c *fiber.Ctx) error {
-	err := s.Sessions.DeleteSession(c.Locals("sessionID").(string))
+	sessionID := c.Locals("sessionID").(string)
+
+	err := s.Sessions.DeleteSession(sessionID)
 	if err != nil {
 		if errors.Is(err, models.ErrNoSessionsFound) {
 			return internal.ClientError(c, http.StatusUnprocessableEntity, internal.DefaultError{
@@ -187,6 +282,8 @@ func (s *Server) Logout(c *fiber.Ctx) error {
 		}
 	}
 
+	s.Websocket.CloseSession(sessionID)
+
 	return c.JSON(map[string]string{
 		"msg": "Session closed",
 	})
@@ -225,6 +322,14 @@ func (s *Server) Token(c *fiber.Ctx) error {
 				Code:    "INVALID_SESSION",
 				Message: "Invalid session data",
 			})
+		case errors.Is(err, models.ErrRefreshTokenReused):
+			// The session was ended, make sure nobody stays connected with it
+			s.Websocket.CloseSession(refreshDTO.SessionID)
+
+			return internal.ClientError(c, http.StatusUnauthorized, internal.DefaultError{
+				Code:    "REFRESH_TOKEN_REUSED",
+				Message: "The refresh token has already been used, please log in again",
+			})
 		case errors.Is(err, models.ErrSessionExpired):
 			return internal.ClientError(c, http.StatusUnauthorized, internal.DefaultError{
 				Code:    "SESSION_EXPIRED",
@@ -235,7 +340,7 @@ func (s *Server) Token(c *fiber.Ctx) error {
 		}
 	}
 
-	accessToken, err := jwt.GenerateAccessToken(newSession.UserID, newSession.SessionID)
+	accessToken, err := s.Keys.GenerateAccessToken(newSession.UserID, newSession.SessionID)
 	if err != nil {
 		return internal.ServerError(c, err, "Unable to revalidate session")
 	}
@@ -250,3 +355,10 @@ func (s *Server) Token(c *fiber.Ctx) error {
 		"expiresIn": jwt.AccessTokenExpirationDelta / time.Millisecond,
 	})
 }
+
+// GetJWKS publishes the public keys access tokens are signed with.
+func (s *Server) GetJWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+
+	return c.JSON(s.Keys.JWKS())
+}
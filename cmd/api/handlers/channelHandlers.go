@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/validator"
+	"github.com/gofiber/fiber/v2"
+)
+
+type createChannelDTO struct {
+	ChannelName string `validate:"min=1,max=100,req"`
+	Description string `validate:"max=1024"`
+	Members     []string
+}
+
+var errChannelNotFound = internal.DefaultError{
+	Code:    "CHANNEL_NOT_FOUND",
+	Message: "Channel with the given ID does not exist",
+}
+
+var errMissingPermissions = internal.DefaultError{
+	Code:    "MISSING_PERMISSIONS",
+	Message: "You don't have permission to perform this action",
+}
+
+var errNotGroupChannel = internal.DefaultError{
+	Code:    "NOT_GROUP_CHANNEL",
+	Message: "This action can only be performed on group channels",
+}
+
+// fetchChannelAsMember fetches a channel along with the membership of the given user.
+// Non members get ErrChannelNotFound so the existence of the channel is not revealed.
+func (s *Server) fetchChannelAsMember(channelID, userID string) (models.Channel, models.ChannelMember, error) {
+	member, err := s.Channels.FetchMember(channelID, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotChannelMember) {
+			return models.Channel{}, models.ChannelMember{}, models.ErrChannelNotFound
+		}
+
+		return models.Channel{}, models.ChannelMember{}, err
+	}
+
+	channel, err := s.Channels.FetchChannel(channelID)
+	if err != nil {
+		return models.Channel{}, models.ChannelMember{}, err
+	}
+
+	return channel, member, nil
+}
+
+func (s *Server) CreateChannel(c *fiber.Ctx) error {
+	err := internal.VerifyContentType(c, "application/x-www-form-urlencoded")
+	if err != nil {
+		return internal.ClientError(c, http.StatusUnsupportedMediaType, internal.DefaultError{
+			Code:    "UNSUPPORTED_MEDIA_TYPE",
+			Message: "Content-Type header must be application/x-www-form-urlencoded",
+		})
+	}
+
+	var channelDTO createChannelDTO
+	err = c.BodyParser(&channelDTO)
+	if err != nil {
+		return err
+	}
+
+	result, err := validator.Validate(channelDTO)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to validate request body")
+	}
+
+	if !result.IsValid {
+		return result.SendValidationError(c)
+	}
+
+	channel, err := s.Channels.CreateChannel(c.Locals("userID").(string), channelDTO.ChannelName, channelDTO.Description, channelDTO.Members)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrMaxMembers):
+			return internal.ClientError(c, http.StatusUnprocessableEntity, internal.DefaultError{
+				Code:    "MAX_MEMBERS",
+				Message: "Channels can't have more than the maximum number of members",
+			})
+		case errors.Is(err, models.ErrNotFriends), errors.Is(err, models.ErrUserNotFound):
+			return internal.ClientError(c, http.StatusUnprocessableEntity, internal.DefaultError{
+				Code:    "NOT_FRIENDS",
+				Message: "You can only add your friends to a channel",
+			})
+		default:
+			return internal.ServerError(c, err, "Failed to create channel")
+		}
+	}
+
+	return c.Status(http.StatusCreated).JSON(channel)
+}
+
+func (s *Server) GetChannels(c *fiber.Ctx) error {
+	channels, err := s.Channels.FetchChannels(c.Locals("userID").(string))
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to fetch channels")
+	}
+
+	return c.JSON(channels)
+}
+
+func (s *Server) GetChannel(c *fiber.Ctx) error {
+	channel, _, err := s.fetchChannelAsMember(c.Params("channelID"), c.Locals("userID").(string))
+	if err != nil {
+		if errors.Is(err, models.ErrChannelNotFound) {
+			return internal.ClientError(c, http.StatusNotFound, errChannelNotFound)
+		}
+
+		return internal.ServerError(c, err, "Failed to fetch channel")
+	}
+
+	return c.JSON(channel)
+}
+
+func (s *Server) DeleteChannel(c *fiber.Ctx) error {
+	channel, _, err := s.fetchChannelAsMember(c.Params("channelID"), c.Locals("userID").(string))
+	if err != nil {
+		if errors.Is(err, models.ErrChannelNotFound) {
+			return internal.ClientError(c, http.StatusNotFound, errChannelNotFound)
+		}
+
+		return internal.ServerError(c, err, "Failed to fetch channel")
+	}
+
+	if channel.ChannelType != models.ChannelTypeGroup {
+		return internal.ClientError(c, http.StatusUnprocessableEntity, errNotGroupChannel)
+	}
+
+	// Only the owner can delete a channel
+	if channel.OwnerID != c.Locals("userID").(string) {
+		return internal.ClientError(c, http.StatusForbidden, errMissingPermissions)
+	}
+
+	err = s.Channels.DeleteChannel(channel.ChannelID)
+	if err != nil && !errors.Is(err, models.ErrChannelNotFound) {
+		return internal.ServerError(c, err, "Failed to delete channel")
+	}
+
+	return c.JSON(map[string]string{
+		"msg": "Channel deleted",
+	})
+}
+
+func (s *Server) GetChannelMembers(c *fiber.Ctx) error {
+	channel, _, err := s.fetchChannelAsMember(c.Params("channelID"), c.Locals("userID").(string))
+	if err != nil {
+		if errors.Is(err, models.ErrChannelNotFound) {
+			return internal.ClientError(c, http.StatusNotFound, errChannelNotFound)
+		}
+
+		return internal.ServerError(c, err, "Failed to fetch channel")
+	}
+
+	members, err := s.Channels.FetchMembers(channel.ChannelID)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to fetch channel members")
+	}
+
+	return c.JSON(members)
+}
+
+func (s *Server) AddChannelMember(c *fiber.Ctx) error {
+	clientID := c.Locals("userID").(string)
+	userID := c.Params("userID")
+
+	channel, clientMember, err := s.fetchChannelAsMember(c.Params("channelID"), clientID)
+	if err != nil {
+		if errors.Is(err, models.ErrChannelNotFound) {
+			return internal.ClientError(c, http.StatusNotFound, errChannelNotFound)
+		}
+
+		return internal.ServerError(c, err, "Failed to fetch channel")
+	}
+
+	if channel.ChannelType != models.ChannelTypeGroup {
+		return internal.ClientError(c, http.StatusUnprocessableEntity, errNotGroupChannel)
+	}
+
+	if !clientMember.IsAdmin {
+		return internal.ClientError(c, http.StatusForbidden, errMissingPermissions)
+	}
+
+	member, err := s.Channels.AddMember(channel.ChannelID, clientID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrMemberExists):
+			return internal.ClientError(c, http.StatusConflict, internal.DefaultError{
+				Code:    "MEMBER_EXISTS",
+				Message: "User is already a member of this channel",
+			})
+		case errors.Is(err, models.ErrMaxMembers):
+			return internal.ClientError(c, http.StatusUnprocessableEntity, internal.DefaultError{
+				Code:    "MAX_MEMBERS",
+				Message: "This channel has reached the maximum number of members",
+			})
+		case errors.Is(err, models.ErrNotFriends), errors.Is(err, models.ErrUserNotFound):
+			return internal.ClientError(c, http.StatusUnprocessableEntity, internal.DefaultError{
+				Code:    "NOT_FRIENDS",
+				Message: "You can only add your friends to a channel",
+			})
+		case errors.Is(err, models.ErrChannelNotFound):
+			return internal.ClientError(c, http.StatusNotFound, errChannelNotFound)
+		default:
+			return internal.ServerError(c, err, "Failed to add channel member")
+		}
+	}
+
+	return c.Status(http.StatusCreated).JSON(member)
+}
+
+func (s *Server) RemoveChannelMember(c *fiber.Ctx) error {
+	clientID := c.Locals("userID").(string)
+	userID := c.Params("userID")
+
+	channel, clientMember, err := s.fetchChannelAsMember(c.Params("channelID"), clientID)
+	if err != nil {
+		if errors.Is(err, models.ErrChannelNotFound) {
+			return internal.ClientError(c, http.StatusNotFound, errChannelNotFound)
+		}
+
+		return internal.ServerError(c, err, "Failed to fetch channel")
+	}
+
+	if channel.ChannelType != models.ChannelTypeGroup {
+		return internal.ClientError(c, http.StatusUnprocessableEntity, errNotGroupChannel)
+	}
+
+	// The owner can't leave or be kicked, they need to delete the channel instead
+	if userID == channel.OwnerID {
+		return internal.ClientError(c, http.StatusUnprocessableEntity, internal.DefaultError{
+			Code:    "OWNER_CANNOT_LEAVE",
+			Message: "The channel owner can't be removed from the channel",
+		})
+	}
+
+	// Members can always leave, removing someone else requires admin permissions
+	if userID != clientID {
+		if !clientMember.IsAdmin {
+			return internal.ClientError(c, http.StatusForbidden, errMissingPermissions)
+		}
+
+		member, err := s.Channels.FetchMember(channel.ChannelID, userID)
+		if err != nil {
+			if errors.Is(err, models.ErrNotChannelMember) {
+				return internal.ClientError(c, http.StatusNotFound, internal.DefaultError{
+					Code:    "MEMBER_NOT_FOUND",
+					Message: "User is not a member of this channel",
+				})
+			}
+
+			return internal.ServerError(c, err, "Failed to fetch channel member")
+		}
+
+		// Only the owner can remove other admins
+		if member.IsAdmin && clientID != channel.OwnerID {
+			return internal.ClientError(c, http.StatusForbidden, errMissingPermissions)
+		}
+	}
+
+	err = s.Channels.RemoveMember(channel.ChannelID, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotChannelMember) {
+			return internal.ClientError(c, http.StatusNotFound, internal.DefaultError{
+				Code:    "MEMBER_NOT_FOUND",
+				Message: "User is not a member of this channel",
+			})
+		}
+
+		return internal.ServerError(c, err, "Failed to remove channel member")
+	}
+
+	return c.JSON(map[string]string{
+		"msg": "Member removed",
+	})
+}
+
+func (s *Server) GrantChannelAdmin(c *fiber.Ctx) error {
+	return s.changeAdminPerms(c, true)
+}
+
+func (s *Server) RevokeChannelAdmin(c *fiber.Ctx) error {
+	return s.changeAdminPerms(c, false)
+}
+
+func (s *Server) changeAdminPerms(c *fiber.Ctx, isAdmin bool) error {
+	clientID := c.Locals("userID").(string)
+	userID := c.Params("userID")
+
+	channel, _, err := s.fetchChannelAsMember(c.Params("channelID"), clientID)
+	if err != nil {
+		if errors.Is(err, models.ErrChannelNotFound) {
+			return internal.ClientError(c, http.StatusNotFound, errChannelNotFound)
+		}
+
+		return internal.ServerError(c, err, "Failed to fetch channel")
+	}
+
+	if channel.ChannelType != models.ChannelTypeGroup {
+		return internal.ClientError(c, http.StatusUnprocessableEntity, errNotGroupChannel)
+	}
+
+	// Only the owner can manage admins and the owner is always an admin
+	if channel.OwnerID != clientID || userID == channel.OwnerID {
+		return internal.ClientError(c, http.StatusForbidden, errMissingPermissions)
+	}
+
+	err = s.Channels.ChangeAdminPerms(channel.ChannelID, userID, isAdmin)
+	if err != nil {
+		if errors.Is(err, models.ErrNotChannelMember) {
+			return internal.ClientError(c, http.StatusNotFound, internal.DefaultError{
+				Code:    "MEMBER_NOT_FOUND",
+				Message: "User is not a member of this channel",
+			})
+		}
+
+		return internal.ServerError(c, err, "Failed to update admin permissions")
+	}
+
+	return c.JSON(map[string]any{
+		"userID":  userID,
+		"isAdmin": isAdmin,
+	})
+}
+
+func (s *Server) HideChannel(c *fiber.Ctx) error {
+	return s.changeHiddenState(c, true)
+}
+
+func (s *Server) UnhideChannel(c *fiber.Ctx) error {
+	return s.changeHiddenState(c, false)
+}
+
+func (s *Server) changeHiddenState(c *fiber.Ctx, hidden bool) error {
+	channelID := c.Params("channelID")
+
+	err := s.Channels.ChangeChannelHiddenState(channelID, c.Locals("userID").(string), hidden)
+	if err != nil {
+		if errors.Is(err, models.ErrNotChannelMember) {
+			return internal.ClientError(c, http.StatusNotFound, errChannelNotFound)
+		}
+
+		return internal.ServerError(c, err, "Failed to update channel")
+	}
+
+	return c.JSON(map[string]any{
+		"channelID": channelID,
+		"hidden":    hidden,
+	})
+}
@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/mailer"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+var errExportNotFound = internal.DefaultError{
+	Code:    "EXPORT_NOT_FOUND",
+	Message: "Data export not found",
+}
+
+// RequestDataExport queues a copy of the user's data, the archive is built in the background.
+func (s *Server) RequestDataExport(c *fiber.Ctx) error {
+	export, err := s.DataExports.CreateExport(c.Locals("userID").(string))
+	if err != nil {
+		if errors.Is(err, models.ErrExportInProgress) {
+			return internal.ClientError(c, http.StatusConflict, internal.DefaultError{
+				Code:    "EXPORT_IN_PROGRESS",
+				Message: "A data export is already being prepared",
+			})
+		}
+
+		return internal.ServerError(c, err, "Failed to request data export")
+	}
+
+	// Wake the worker up, unless it already has been
+	select {
+	case s.DataExportRequests <- struct{}{}:
+	default:
+	}
+
+	return c.Status(http.StatusAccepted).JSON(export)
+}
+
+// GetDataExport returns the status of an export. Once it's ready the response includes a short lived download link,
+// a new one is issued on every request. Links point at the web client's public URL like every other link we hand out,
+// which forwards /exports to the API.
+func (s *Server) GetDataExport(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	export, err := s.DataExports.FetchExport(userID, c.Params("exportID"))
+	if err != nil {
+		if errors.Is(err, models.ErrExportNotFound) {
+			return internal.ClientError(c, http.StatusNotFound, errExportNotFound)
+		}
+
+		return internal.ServerError(c, err, "Failed to fetch data export")
+	}
+
+	if export.Status != models.ExportReady {
+		return c.JSON(export)
+	}
+
+	token, err := internal.GenerateSecureToken()
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to create download link")
+	}
+
+	linkExpiresAt, err := s.DataExports.CreateDownloadLink(userID, export.ExportID, internal.HashToken(token))
+	if err != nil {
+		// Expired since it was fetched, the cleanup job will mark it as such
+		if errors.Is(err, models.ErrExportNotFound) {
+			export.Status = models.ExportExpired
+			return c.JSON(export)
+		}
+
+		return internal.ServerError(c, err, "Failed to create download link")
+	}
+
+	return c.JSON(map[string]any{
+		"exportID":      export.ExportID,
+		"status":        export.Status,
+		"createdAt":     export.CreatedAt,
+		"completedAt":   export.CompletedAt,
+		"expiresAt":     export.ExpiresAt,
+		"downloadURL":   s.APIURL + "/exports/" + token,
+		"linkExpiresAt": linkExpiresAt,
+	})
+}
+
+// DownloadDataExport serves the archive of a download link. The token in the link is the only credential
+// so it can be opened directly by the browser.
+func (s *Server) DownloadDataExport(c *fiber.Ctx) error {
+	archive, createdAt, err := s.DataExports.FetchArchive(internal.HashToken(c.Params("token")))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			return internal.ClientError(c, http.StatusNotFound, internal.DefaultError{
+				Code:    "INVALID_TOKEN",
+				Message: "The download link is invalid or has expired",
+			})
+		}
+
+		return internal.ServerError(c, err, "Failed to fetch data export")
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Attachment("iris-data-" + createdAt.UTC().Format("2006-01-02") + ".zip")
+
+	return c.SendStream(archive)
+}
+
+// ProcessDataExports is the data export worker. It builds queued exports one at a time and deletes expired archives,
+// running when an export is requested through DataExportRequests and every interval until the context is cancelled.
+// The interval also picks up exports left behind by instances that stopped.
+func (s *Server) ProcessDataExports(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.processDataExports(ctx)
+
+		err := s.DataExports.DeleteExpiredArchives()
+		if err != nil {
+			log.Errorf("Failed to delete expired data exports: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.DataExportRequests:
+		}
+	}
+}
+
+// processDataExports builds queued exports until there are none left.
+func (s *Server) processDataExports(ctx context.Context) {
+	for ctx.Err() == nil {
+		claim, ok, err := s.DataExports.ClaimExport()
+		if err != nil {
+			log.Errorf("Failed to claim data export: %v", err)
+			return
+		}
+
+		if !ok {
+			return
+		}
+
+		s.processDataExport(ctx, claim)
+	}
+}
+
+// processDataExport builds and stores the claimed export, emailing the user once it's ready.
+func (s *Server) processDataExport(ctx context.Context, claim models.ExportClaim) {
+	buildCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// Keep the claim fresh while building so other instances don't take the export for an abandoned one
+	go func() {
+		ticker := time.NewTicker(models.DataExportStaleAfter / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-buildCtx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			err := s.DataExports.RefreshClaim(claim)
+			if errors.Is(err, models.ErrExportClaimLost) {
+				cancel(err)
+				return
+			}
+
+			if err != nil {
+				log.Errorf("Failed to refresh the claim of data export %s: %v", claim.ExportID, err)
+			}
+		}
+	}()
+
+	archive := s.DataExports.NewArchiveWriter(claim)
+
+	err := s.buildDataExport(buildCtx, claim.UserID, archive)
+	if err == nil {
+		err = archive.Close()
+	}
+
+	if err == nil {
+		err = context.Cause(buildCtx)
+	}
+
+	if errors.Is(err, models.ErrExportClaimLost) {
+		log.Warnf("Data export %s was claimed by another worker, stopped building it", claim.ExportID)
+		return
+	}
+
+	// Shutting down, the export is picked up again once its claim is stale
+	if ctx.Err() != nil {
+		return
+	}
+
+	if err != nil {
+		log.Errorf("Failed to build data export %s: %v", claim.ExportID, err)
+
+		err = s.DataExports.FailExport(claim)
+		if err != nil {
+			log.Errorf("Failed to mark data export %s as failed: %v", claim.ExportID, err)
+		}
+		return
+	}
+
+	expiresAt, err := s.DataExports.CompleteExport(claim)
+	if err != nil {
+		log.Errorf("Failed to save data export %s: %v", claim.ExportID, err)
+		return
+	}
+
+	s.sendDataExportEmail(claim.UserID, expiresAt)
+}
+
+func (s *Server) sendDataExportEmail(userID string, expiresAt time.Time) {
+	user, err := s.Users.FetchUser(userID)
+	if err != nil {
+		log.Errorf("Failed to fetch user for data export email: %v", err)
+		return
+	}
+
+	subject, body := mailer.DataExportReadyEmail(s.AppURL+"/settings/privacy", expiresAt)
+
+	err = s.Mailer.Send(user.Email, subject, body)
+	if err != nil {
+		log.Errorf("Failed to send data export email: %v", err)
+	}
+}
+
+// Number of messages fetched at a time while building an export
+const exportMessagesPageSize = 1000
+
+// buildDataExport writes the user's data as a zip archive with one JSON file per kind of data.
+// Messages are written a page at a time, so the archive never has to fit in memory.
+func (s *Server) buildDataExport(ctx context.Context, userID string, w io.Writer) error {
+	profile, err := s.Users.FetchUser(userID)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := s.Sessions.FetchSessions(userID)
+	if err != nil {
+		return err
+	}
+
+	friends, err := s.Relationships.FetchFriends(userID)
+	if err != nil {
+		return err
+	}
+
+	friendRequests, err := s.Relationships.FetchFriendRequests(userID)
+	if err != nil {
+		return err
+	}
+
+	blockedUsers, err := s.Relationships.FetchBlockedUsers(userID)
+	if err != nil {
+		return err
+	}
+
+	memberships, err := s.DataExports.FetchMemberships(userID)
+	if err != nil {
+		return err
+	}
+
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", profile},
+		{"sessions.json", sessions},
+		{"relationships.json", map[string]any{"friends": friends, "requests": friendRequests}},
+		{"blocked_users.json", blockedUsers},
+		{"channels.json", memberships},
+	}
+
+	zipWriter := zip.NewWriter(w)
+
+	for _, file := range files {
+		writer, err := zipWriter.Create(file.name)
+		if err != nil {
+			return err
+		}
+
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+
+		err = encoder.Encode(file.data)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer, err := zipWriter.Create("messages.json")
+	if err != nil {
+		return err
+	}
+
+	err = s.writeAuthoredMessages(ctx, userID, writer)
+	if err != nil {
+		return err
+	}
+
+	return zipWriter.Close()
+}
+
+// writeAuthoredMessages writes every message the user wrote as a JSON array, oldest first.
+func (s *Server) writeAuthoredMessages(ctx context.Context, userID string, w io.Writer) error {
+	_, err := io.WriteString(w, "[")
+	if err != nil {
+		return err
+	}
+
+	cursor := ""
+	for {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+
+		messages, err := s.Messages.FetchAuthoredMessages(userID, cursor, exportMessagesPageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, message := range messages {
+			separator := ",\n  "
+			if cursor == "" {
+				separator = "\n  "
+			}
+
+			data, err := json.MarshalIndent(message, "  ", "  ")
+			if err != nil {
+				return err
+			}
+
+			_, err = io.WriteString(w, separator)
+			if err != nil {
+				return err
+			}
+
+			_, err = w.Write(data)
+			if err != nil {
+				return err
+			}
+
+			cursor = message.MessageID
+		}
+
+		if len(messages) < exportMessagesPageSize {
+			break
+		}
+	}
+
+	if cursor != "" {
+		_, err = io.WriteString(w, "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(w, "]\n")
+	return err
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/validator"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// Minimum time between two emails with a link of the same kind for the same user
+var emailLinkResendInterval = time.Minute
+
+// tokenStore keeps the single use tokens of one kind of email link.
+type tokenStore interface {
+	CreateToken(userID, tokenHash string) (time.Time, error)
+	LastIssuedAt(userID string) (time.Time, error)
+}
+
+// emailLink is a kind of link to the web client carrying a single use token, sent by email.
+type emailLink struct {
+	name     string // What the link is for, used in logs
+	tokens   tokenStore
+	path     string // Page of the web client the link opens
+	template func(link string, expiresAt time.Time) (subject, body string)
+}
+
+type emailLinkDTO struct {
+	Email string `validate:"email,req"`
+}
+
+// sendEmailLink issues a new token for the user and emails them the link with it.
+func (s *Server) sendEmailLink(link emailLink, userID, email string) error {
+	token, err := internal.GenerateSecureToken()
+	if err != nil {
+		return err
+	}
+
+	expiresAt, err := link.tokens.CreateToken(userID, internal.HashToken(token))
+	if err != nil {
+		return err
+	}
+
+	subject, body := link.template(s.AppURL+link.path+"?token="+url.QueryEscape(token), expiresAt)
+
+	return s.Mailer.Send(email, subject, body)
+}
+
+// sendEmailLinkTo emails the link to the owner of the email, if there is one and they weren't sent one recently.
+func (s *Server) sendEmailLinkTo(link emailLink, email string) error {
+	user, err := s.Users.FetchUserByEmail(email)
+	if err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			return nil
+		}
+
+		return err
+	}
+
+	lastIssued, err := link.tokens.LastIssuedAt(user.UserID)
+	if err != nil {
+		return err
+	}
+
+	if time.Since(lastIssued) < emailLinkResendInterval {
+		return nil
+	}
+
+	return s.sendEmailLink(link, user.UserID, user.Email)
+}
+
+// requestEmailLink handles a request for a link sent to the email in the body, responding with msg.
+func (s *Server) requestEmailLink(c *fiber.Ctx, link emailLink, msg string) error {
+	err := internal.VerifyContentType(c, "application/x-www-form-urlencoded")
+	if err != nil {
+		return internal.ClientError(c, http.StatusUnsupportedMediaType, internal.DefaultError{
+			Code:    "UNSUPPORTED_MEDIA_TYPE",
+			Message: "Content-Type header must be application/x-www-form-urlencoded",
+		})
+	}
+
+	var linkDTO emailLinkDTO
+	err = c.BodyParser(&linkDTO)
+	if err != nil {
+		return err
+	}
+
+	result, err := validator.Validate(linkDTO)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to validate request body")
+	}
+
+	if !result.IsValid {
+		return result.SendValidationError(c)
+	}
+
+	// The email is sent in the background so the response looks and takes the same
+	// whether or not the email belongs to an account
+	go func() {
+		err := s.sendEmailLinkTo(link, linkDTO.Email)
+		if err != nil {
+			log.Errorf("Failed to send %s email: %v", link.name, err)
+		}
+	}()
+
+	return c.JSON(map[string]string{
+		"msg": msg,
+	})
+}
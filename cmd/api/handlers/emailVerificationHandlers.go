@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/mailer"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/validator"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+type verifyEmailDTO struct {
+	Token string `validate:"req"`
+}
+
+func (s *Server) verificationLink() emailLink {
+	return emailLink{
+		name:     "verification",
+		tokens:   s.EmailVerifications,
+		path:     "/verify-email",
+		template: mailer.VerificationEmail,
+	}
+}
+
+func (s *Server) VerifyEmail(c *fiber.Ctx) error {
+	err := internal.VerifyContentType(c, "application/x-www-form-urlencoded")
+	if err != nil {
+		return internal.ClientError(c, http.StatusUnsupportedMediaType, internal.DefaultError{
+			Code:    "UNSUPPORTED_MEDIA_TYPE",
+			Message: "Content-Type header must be application/x-www-form-urlencoded",
+		})
+	}
+
+	var verifyDTO verifyEmailDTO
+	err = c.BodyParser(&verifyDTO)
+	if err != nil {
+		return err
+	}
+
+	result, err := validator.Validate(verifyDTO)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to validate request body")
+	}
+
+	if !result.IsValid {
+		return result.SendValidationError(c)
+	}
+
+	err = s.EmailVerifications.ConsumeToken(internal.HashToken(verifyDTO.Token))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			return internal.ClientError(c, http.StatusBadRequest, internal.DefaultError{
+				Code:    "INVALID_TOKEN",
+				Message: "The verification link is invalid or has expired",
+			})
+		}
+
+		return internal.ServerError(c, err, "Failed to verify email")
+	}
+
+	return c.JSON(map[string]string{
+		"msg": "Email verified",
+	})
+}
+
+func (s *Server) ResendVerificationEmail(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	user, err := s.Users.FetchUser(userID)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to fetch user data")
+	}
+
+	if user.Verified {
+		return internal.ClientError(c, http.StatusConflict, internal.DefaultError{
+			Code:    "ALREADY_VERIFIED",
+			Message: "Your email has already been verified",
+		})
+	}
+
+	lastIssued, err := s.EmailVerifications.LastIssuedAt(userID)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to send verification email")
+	}
+
+	if wait := emailLinkResendInterval - time.Since(lastIssued); wait > 0 {
+		return sendRetryAfter(c, wait, internal.DefaultError{
+			Code:    "TOO_MANY_REQUESTS",
+			Message: "Please wait before requesting another verification email",
+		})
+	}
+
+	err = s.sendEmailLink(s.verificationLink(), userID, user.Email)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to send verification email")
+	}
+
+	return c.JSON(map[string]string{
+		"msg": "Verification email sent",
+	})
+}
+
+// queueVerificationEmail sends the verification email in the background so a slow mail server
+// doesn't hold up the request that created the account.
+func (s *Server) queueVerificationEmail(userID, email string) {
+	go func() {
+		err := s.sendEmailLink(s.verificationLink(), userID, email)
+		if err != nil {
+			log.Errorf("Failed to send verification email to user %s: %v", userID, err)
+		}
+	}()
+}
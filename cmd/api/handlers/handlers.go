@@ -2,8 +2,13 @@ package handlers
 
 import (
 	"github.com/CDavidSV/Iris-Chat-App-Backend/cmd/api/middleware"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/auth"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/jwt"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/mailer"
 	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/oidc"
 	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/websocket"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/gofiber/fiber/v2"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -14,6 +19,30 @@ type Server struct {
 	Sessions      *models.SessionsModel
 	Relationships *models.RelationshipModel
 	Channels      *models.ChannelModel
+	Messages      *models.MessageModel
+
+	EmailVerifications *models.EmailVerificationModel
+	PasswordResets     *models.PasswordResetModel
+	MagicLinks         *models.MagicLinkModel
+	MFA                *models.MFAModel
+	Passkeys           *models.PasskeyModel
+	Mailer             mailer.Mailer
+	WebAuthn           *webauthn.WebAuthn
+	Keys               *jwt.KeyManager
+	Verifier           *auth.Verifier
+	LoginThrottle      *auth.LoginThrottle
+	Identities         *models.IdentityModel
+	AccountDeletions   *models.AccountDeletionModel
+	DataExports        *models.DataExportModel
+	OIDC               *oidc.Providers
+
+	// Wakes the data export worker up when an export is requested
+	DataExportRequests chan struct{}
+
+	// Base URL of the web client, used to build the links sent by email
+	AppURL string
+	// Base URL of the API itself, used to build links to files it serves directly
+	APIURL string
 
 	Websocket *websocket.WebsocketServer
 }
@@ -22,27 +51,81 @@ func (s *Server) LoadRoutes(app *fiber.App) {
 	// ------------------ Unprotected routes ------------------
 	app.Post("/auth/signup", s.Register)
 	app.Post("/auth/login", s.Login)
+	app.Post("/auth/verify-email", s.VerifyEmail)
+	app.Post("/auth/forgot-password", s.ForgotPassword)
+	app.Post("/auth/reset-password", s.ResetPassword)
+	app.Post("/auth/magic-link", s.RequestMagicLink)
+	app.Post("/auth/magic-link/consume", s.ConsumeMagicLink)
+	app.Post("/auth/mfa/verify", s.VerifyMFA)
+	app.Post("/auth/passkeys/login", s.BeginPasskeyLogin)
+	app.Post("/auth/passkeys/login/:ceremonyID", s.FinishPasskeyLogin)
+	app.Get("/auth/oidc/providers", s.GetOIDCProviders)
+	app.Post("/auth/oidc/:provider/authorize", s.BeginOIDCLogin)
+	app.Post("/auth/oidc/:provider/callback", s.FinishOIDCLogin)
+	app.Get("/exports/:token", s.DownloadDataExport)
+	app.Get("/.well-known/jwks.json", s.GetJWKS)
 
 	// ------------------ Protected routes ------------------
+	authorize := middleware.Authorize(s.Verifier)
+	requireVerified := middleware.RequireVerified(s.Users)
 
 	// Auth
-	app.Post("/auth/logout", middleware.Authorize, s.Logout)
+	app.Post("/auth/logout", authorize, s.Logout)
 	app.Post("/auth/token", s.Token)
+	app.Post("/auth/verify-email/resend", authorize, s.ResendVerificationEmail)
+	app.Get("/auth/sessions", authorize, s.GetSessions)
+	app.Delete("/auth/sessions/others", authorize, s.RevokeOtherSessions)
+	app.Delete("/auth/sessions/:sessionID", authorize, s.RevokeSession)
+	app.Post("/auth/mfa/totp", authorize, s.EnrollTOTP)
+	app.Post("/auth/mfa/totp/confirm", authorize, s.ConfirmTOTP)
+	app.Post("/auth/mfa/totp/disable", authorize, s.DisableTOTP)
+	app.Post("/auth/mfa/recovery-codes", authorize, s.RegenerateRecoveryCodes)
+	app.Get("/auth/passkeys", authorize, s.GetPasskeys)
+	app.Post("/auth/passkeys/register", authorize, s.BeginPasskeyRegistration)
+	app.Post("/auth/passkeys/register/:ceremonyID", authorize, s.FinishPasskeyRegistration)
+	app.Delete("/auth/passkeys/:credentialID", authorize, s.DeletePasskey)
+	app.Get("/auth/identities", authorize, s.GetIdentities)
+	app.Delete("/auth/identities/:provider", authorize, s.UnlinkIdentity)
+	app.Post("/auth/oidc/:provider/link", authorize, s.BeginOIDCLink)
+	app.Post("/auth/oidc/:provider/link/callback", authorize, s.FinishOIDCLink)
 
 	// User
-	app.Get("users/me", middleware.Authorize, s.GetMe)
-	app.Get("users/username/:username", middleware.Authorize, s.GetUsersByUsername)
-	app.Get("users/id/:userID", middleware.Authorize, s.GetUser)
+	app.Get("users/me", authorize, s.GetMe)
+	app.Get("users/username/:username", authorize, s.GetUsersByUsername)
+	app.Get("users/id/:userID", authorize, s.GetUser)
 
 	// Relationships
-	app.Get("/relationships/friends", middleware.Authorize, s.GetFriendships)
-	app.Get("/relationships/requests", middleware.Authorize, s.GetFriendRequests)
-	app.Get("/relationships/blocked", middleware.Authorize, s.GetBlockedUsers)
-	app.Post("/relationships/:userID", middleware.Authorize, s.CreateRelationship)
-	app.Delete("/relationships/:userID", middleware.Authorize, s.DelRelationship)
-	app.Put("/relationships/:userID", middleware.Authorize, s.BlockUser)
+	app.Get("/relationships/friends", authorize, s.GetFriendships)
+	app.Get("/relationships/requests", authorize, s.GetFriendRequests)
+	app.Get("/relationships/blocked", authorize, s.GetBlockedUsers)
+	app.Post("/relationships/:userID", authorize, requireVerified, s.CreateRelationship)
+	app.Delete("/relationships/:userID", authorize, s.DelRelationship)
+	app.Put("/relationships/:userID", authorize, s.BlockUser)
+
+	// Channels
+	app.Get("/channels", authorize, s.GetChannels)
+	app.Post("/channels", authorize, requireVerified, s.CreateChannel)
+	app.Get("/channels/:channelID", authorize, s.GetChannel)
+	app.Delete("/channels/:channelID", authorize, s.DeleteChannel)
+	app.Put("/channels/:channelID/hidden", authorize, s.HideChannel)
+	app.Delete("/channels/:channelID/hidden", authorize, s.UnhideChannel)
+	app.Get("/channels/:channelID/members", authorize, s.GetChannelMembers)
+	app.Put("/channels/:channelID/members/:userID", authorize, s.AddChannelMember)
+	app.Delete("/channels/:channelID/members/:userID", authorize, s.RemoveChannelMember)
+	app.Put("/channels/:channelID/admins/:userID", authorize, s.GrantChannelAdmin)
+	app.Delete("/channels/:channelID/admins/:userID", authorize, s.RevokeChannelAdmin)
+
+	// Messages
+	app.Get("/channels/:channelID/messages", authorize, s.GetMessages)
+	app.Post("/channels/:channelID/messages", authorize, s.SendMessage)
+	app.Get("/channels/:channelID/messages/:messageID", authorize, s.GetMessage)
+	app.Put("/channels/:channelID/messages/:messageID", authorize, s.EditMessage)
+	app.Delete("/channels/:channelID/messages/:messageID", authorize, s.DeleteMessage)
 
 	// Profile
-	app.Put("/profile/update", middleware.Authorize, s.UpdateProfile)
-	app.Post("/profile/change-password", middleware.Authorize, s.ChangePassword)
+	app.Put("/profile/update", authorize, s.UpdateProfile)
+	app.Post("/profile/change-password", authorize, s.ChangePassword)
+	app.Post("/profile/delete", authorize, s.DeleteAccount)
+	app.Post("/profile/export", authorize, s.RequestDataExport)
+	app.Get("/profile/export/:exportID", authorize, s.GetDataExport)
 }
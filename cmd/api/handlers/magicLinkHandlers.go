@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/mailer"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/validator"
+	"github.com/gofiber/fiber/v2"
+)
+
+type consumeMagicLinkDTO struct {
+	Token    string `validate:"req"`
+	Platform string `validate:"req"`
+	OS       string `validate:"req"`
+}
+
+func (s *Server) magicLink() emailLink {
+	return emailLink{
+		name:     "login link",
+		tokens:   s.MagicLinks,
+		path:     "/magic-link",
+		template: mailer.MagicLinkEmail,
+	}
+}
+
+func (s *Server) RequestMagicLink(c *fiber.Ctx) error {
+	return s.requestEmailLink(c, s.magicLink(), "If an account exists for this email, a login link has been sent to it")
+}
+
+// ConsumeMagicLink logs in the user the link was sent to. The session is created for the device opening
+// the link, which doesn't have to be the one that asked for it.
+func (s *Server) ConsumeMagicLink(c *fiber.Ctx) error {
+	err := internal.VerifyContentType(c, "application/x-www-form-urlencoded")
+	if err != nil {
+		return internal.ClientError(c, http.StatusUnsupportedMediaType, internal.DefaultError{
+			Code:    "UNSUPPORTED_MEDIA_TYPE",
+			Message: "Content-Type header must be application/x-www-form-urlencoded",
+		})
+	}
+
+	var consumeDTO consumeMagicLinkDTO
+	err = c.BodyParser(&consumeDTO)
+	if err != nil {
+		return err
+	}
+
+	result, err := validator.Validate(consumeDTO)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to validate request body")
+	}
+
+	if !result.IsValid {
+		return result.SendValidationError(c)
+	}
+
+	userID, err := s.MagicLinks.ConsumeToken(internal.HashToken(consumeDTO.Token))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			return internal.ClientError(c, http.StatusBadRequest, internal.DefaultError{
+				Code:    "INVALID_TOKEN",
+				Message: "The login link is invalid or has expired",
+			})
+		}
+
+		return internal.ServerError(c, err, "Failed to authenticate user")
+	}
+
+	// The link only proves access to the email, users with two-factor authentication still need their second factor
+	return s.completeLogin(c, userID, consumeDTO.Platform, consumeDTO.OS)
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/validator"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/oklog/ulid/v2"
+)
+
+type sendMessageDTO struct {
+	Content string `validate:"min=1,max=2000,req"`
+}
+
+var errMessageNotFound = internal.DefaultError{
+	Code:    "MESSAGE_NOT_FOUND",
+	Message: "Message with the given ID does not exist",
+}
+
+var errBlocked = internal.DefaultError{
+	Code:    "BLOCKED",
+	Message: "You can't interact with this channel because one of its members is blocked",
+}
+
+// checkChannelAccess makes sure the user is a member of the channel and isn't blocked from it.
+// It returns ErrChannelNotFound for non members and ErrRecipientHasBlockedUser when a block exists.
+func (s *Server) checkChannelAccess(channelID, userID string) (models.ChannelMember, error) {
+	member, err := s.Channels.FetchMember(channelID, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotChannelMember) {
+			return models.ChannelMember{}, models.ErrChannelNotFound
+		}
+
+		return models.ChannelMember{}, err
+	}
+
+	blocked, err := s.Channels.IsBlocked(channelID, userID)
+	if err != nil {
+		return models.ChannelMember{}, err
+	}
+
+	if blocked {
+		return models.ChannelMember{}, models.ErrRecipientHasBlockedUser
+	}
+
+	return member, nil
+}
+
+func (s *Server) sendChannelAccessError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, models.ErrChannelNotFound):
+		return internal.ClientError(c, http.StatusNotFound, errChannelNotFound)
+	case errors.Is(err, models.ErrRecipientHasBlockedUser):
+		return internal.ClientError(c, http.StatusForbidden, errBlocked)
+	default:
+		return internal.ServerError(c, err, "Failed to verify channel access")
+	}
+}
+
+func (s *Server) GetMessages(c *fiber.Ctx) error {
+	channelID := c.Params("channelID")
+
+	_, err := s.checkChannelAccess(channelID, c.Locals("userID").(string))
+	if err != nil {
+		return s.sendChannelAccessError(c, err)
+	}
+
+	query := models.MessageQuery{
+		Before: c.Query("before"),
+		After:  c.Query("after"),
+		Around: c.Query("around"),
+		Limit:  c.QueryInt("limit", models.DefaultMessageLimit),
+	}
+
+	cursors := 0
+	for _, cursor := range []string{query.Before, query.After, query.Around} {
+		if cursor == "" {
+			continue
+		}
+
+		cursors++
+		if _, err := ulid.ParseStrict(cursor); err != nil {
+			return internal.ClientError(c, http.StatusBadRequest, internal.DefaultError{
+				Code:    "INVALID_CURSOR",
+				Message: "Message cursors must be valid message IDs",
+			})
+		}
+	}
+
+	if cursors > 1 {
+		return internal.ClientError(c, http.StatusBadRequest, internal.DefaultError{
+			Code:    "INVALID_CURSOR",
+			Message: "Only one of before, after or around can be used at a time",
+		})
+	}
+
+	if query.Limit < 1 || query.Limit > models.MaxMessageLimit {
+		return internal.ClientError(c, http.StatusBadRequest, internal.DefaultError{
+			Code:    "INVALID_LIMIT",
+			Message: "Limit must be between 1 and 100",
+		})
+	}
+
+	messages, err := s.Messages.FetchMessages(channelID, query)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to fetch messages")
+	}
+
+	return c.JSON(messages)
+}
+
+func (s *Server) GetMessage(c *fiber.Ctx) error {
+	channelID := c.Params("channelID")
+
+	_, err := s.checkChannelAccess(channelID, c.Locals("userID").(string))
+	if err != nil {
+		return s.sendChannelAccessError(c, err)
+	}
+
+	message, err := s.Messages.FetchMessage(channelID, c.Params("messageID"))
+	if err != nil {
+		if errors.Is(err, models.ErrMessageNotFound) {
+			return internal.ClientError(c, http.StatusNotFound, errMessageNotFound)
+		}
+
+		return internal.ServerError(c, err, "Failed to fetch message")
+	}
+
+	return c.JSON(message)
+}
+
+func (s *Server) SendMessage(c *fiber.Ctx) error {
+	err := internal.VerifyContentType(c, "application/x-www-form-urlencoded")
+	if err != nil {
+		return internal.ClientError(c, http.StatusUnsupportedMediaType, internal.DefaultError{
+			Code:    "UNSUPPORTED_MEDIA_TYPE",
+			Message: "Content-Type header must be application/x-www-form-urlencoded",
+		})
+	}
+
+	channelID := c.Params("channelID")
+	clientID := c.Locals("userID").(string)
+
+	_, err = s.checkChannelAccess(channelID, clientID)
+	if err != nil {
+		return s.sendChannelAccessError(c, err)
+	}
+
+	var messageDTO sendMessageDTO
+	err = c.BodyParser(&messageDTO)
+	if err != nil {
+		return err
+	}
+
+	result, err := validator.Validate(messageDTO)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to validate request body")
+	}
+
+	if !result.IsValid {
+		return result.SendValidationError(c)
+	}
+
+	message, err := s.Messages.SendMessage(channelID, clientID, messageDTO.Content)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to send message")
+	}
+
+	s.Websocket.StopTyping(channelID, clientID)
+	go s.Websocket.DispatchToChannel(channelID, websocket.EventMessageCreate, message)
+
+	return c.Status(http.StatusCreated).JSON(message)
+}
+
+func (s *Server) EditMessage(c *fiber.Ctx) error {
+	err := internal.VerifyContentType(c, "application/x-www-form-urlencoded")
+	if err != nil {
+		return internal.ClientError(c, http.StatusUnsupportedMediaType, internal.DefaultError{
+			Code:    "UNSUPPORTED_MEDIA_TYPE",
+			Message: "Content-Type header must be application/x-www-form-urlencoded",
+		})
+	}
+
+	channelID := c.Params("channelID")
+	messageID := c.Params("messageID")
+	clientID := c.Locals("userID").(string)
+
+	_, err = s.checkChannelAccess(channelID, clientID)
+	if err != nil {
+		return s.sendChannelAccessError(c, err)
+	}
+
+	var messageDTO sendMessageDTO
+	err = c.BodyParser(&messageDTO)
+	if err != nil {
+		return err
+	}
+
+	result, err := validator.Validate(messageDTO)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to validate request body")
+	}
+
+	if !result.IsValid {
+		return result.SendValidationError(c)
+	}
+
+	message, err := s.Messages.FetchMessage(channelID, messageID)
+	if err != nil {
+		if errors.Is(err, models.ErrMessageNotFound) {
+			return internal.ClientError(c, http.StatusNotFound, errMessageNotFound)
+		}
+
+		return internal.ServerError(c, err, "Failed to fetch message")
+	}
+
+	// Only the author can edit their messages
+	if string(message.AuthorID) != clientID {
+		return internal.ClientError(c, http.StatusForbidden, errMissingPermissions)
+	}
+
+	message, err = s.Messages.EditMessage(channelID, messageID, clientID, messageDTO.Content)
+	if err != nil {
+		if errors.Is(err, models.ErrMessageNotFound) {
+			return internal.ClientError(c, http.StatusNotFound, errMessageNotFound)
+		}
+
+		return internal.ServerError(c, err, "Failed to edit message")
+	}
+
+	go s.Websocket.DispatchToChannel(channelID, websocket.EventMessageUpdate, message)
+
+	return c.JSON(message)
+}
+
+func (s *Server) DeleteMessage(c *fiber.Ctx) error {
+	channelID := c.Params("channelID")
+	messageID := c.Params("messageID")
+	clientID := c.Locals("userID").(string)
+
+	member, err := s.checkChannelAccess(channelID, clientID)
+	if err != nil {
+		return s.sendChannelAccessError(c, err)
+	}
+
+	message, err := s.Messages.FetchMessage(channelID, messageID)
+	if err != nil {
+		if errors.Is(err, models.ErrMessageNotFound) {
+			return internal.ClientError(c, http.StatusNotFound, errMessageNotFound)
+		}
+
+		return internal.ServerError(c, err, "Failed to fetch message")
+	}
+
+	// Authors can delete their own messages and admins can delete anyone's
+	if string(message.AuthorID) != clientID && !member.IsAdmin {
+		return internal.ClientError(c, http.StatusForbidden, errMissingPermissions)
+	}
+
+	err = s.Messages.DeleteMessage(channelID, messageID)
+	if err != nil {
+		if errors.Is(err, models.ErrMessageNotFound) {
+			return internal.ClientError(c, http.StatusNotFound, errMessageNotFound)
+		}
+
+		return internal.ServerError(c, err, "Failed to delete message")
+	}
+
+	go s.Websocket.DispatchToChannel(channelID, websocket.EventMessageDelete, websocket.MessageDelete{
+		MessageID: messageID,
+		ChannelID: channelID,
+	})
+
+	return c.JSON(map[string]string{
+		"msg": "Message deleted",
+	})
+}
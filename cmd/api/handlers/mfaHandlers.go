@@ -0,0 +1,365 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/totp"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/validator"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	totpIssuer        = "Iris"
+	recoveryCodeCount = 10
+)
+
+type mfaCodeDTO struct {
+	Code string `validate:"min=6,max=11,req"` // A TOTP code or a recovery code
+}
+
+type verifyMFADTO struct {
+	MFAToken string `validate:"req"`
+	Code     string `validate:"min=6,max=11,req"`
+}
+
+var errInvalidMFACode = internal.DefaultError{
+	Code:    "INVALID_CODE",
+	Message: "The code is invalid or has already been used",
+}
+
+// normalizeCode strips the formatting users tend to type along with codes.
+func normalizeCode(code string) string {
+	code = strings.ReplaceAll(code, " ", "")
+	code = strings.ReplaceAll(code, "-", "")
+
+	return strings.ToLower(code)
+}
+
+// generateRecoveryCodes returns the codes to show to the user once, and the hashes to store.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for range recoveryCodeCount {
+		b := make([]byte, 7)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b)[:10])
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, internal.HashToken(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// verifySecondFactor checks a TOTP code or consumes a recovery code of a user with two-factor authentication enabled.
+func (s *Server) verifySecondFactor(userID, code string) (bool, error) {
+	code = normalizeCode(code)
+
+	if len(code) != totp.Digits {
+		return s.MFA.UseRecoveryCode(userID, internal.HashToken(code))
+	}
+
+	enrollment, err := s.MFA.FetchEnrollment(userID)
+	if err != nil {
+		if errors.Is(err, models.ErrMFANotEnrolled) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	if !enrollment.Enabled {
+		return false, nil
+	}
+
+	step, ok := totp.Validate(enrollment.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	return s.MFA.UseStep(userID, step)
+}
+
+// checkSecondFactor verifies a TOTP or recovery code of the user through the login throttle, so codes
+// can't be guessed from any endpoint that accepts them. If ok is false the response has already been sent.
+func (s *Server) checkSecondFactor(c *fiber.Ctx, userID, code string) (valid bool, ok bool, err error) {
+	user, err := s.Users.FetchUser(userID)
+	if err != nil {
+		return false, false, internal.ServerError(c, err, "Failed to verify code")
+	}
+
+	attemptID, ok, err := s.startLoginAttempt(c, user.Email)
+	if !ok {
+		return false, false, err
+	}
+
+	valid, err = s.verifySecondFactor(userID, code)
+	if err != nil {
+		return false, false, internal.ServerError(c, err, "Failed to verify code")
+	}
+
+	s.finishLoginAttempt(attemptID, userID, valid)
+
+	return valid, true, nil
+}
+
+// sendMFAChallenge responds to a login with valid credentials that still needs a second factor.
+func (s *Server) sendMFAChallenge(c *fiber.Ctx, userID, platform, os string) error {
+	token, err := internal.GenerateSecureToken()
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to authenticate user")
+	}
+
+	err = s.MFA.CreateChallenge(userID, internal.HashToken(token), platform, os)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to authenticate user")
+	}
+
+	return c.JSON(map[string]any{
+		"msg":         "two-factor authentication required",
+		"mfaRequired": true,
+		"mfaToken":    token,
+		"expiresIn":   models.MFAChallengeExpirationDelta / time.Millisecond,
+	})
+}
+
+// parseMFACode parses and validates a form with a single Code field.
+func parseMFACode(c *fiber.Ctx) (string, bool, error) {
+	err := internal.VerifyContentType(c, "application/x-www-form-urlencoded")
+	if err != nil {
+		return "", false, internal.ClientError(c, http.StatusUnsupportedMediaType, internal.DefaultError{
+			Code:    "UNSUPPORTED_MEDIA_TYPE",
+			Message: "Content-Type header must be application/x-www-form-urlencoded",
+		})
+	}
+
+	var codeDTO mfaCodeDTO
+	err = c.BodyParser(&codeDTO)
+	if err != nil {
+		return "", false, err
+	}
+
+	result, err := validator.Validate(codeDTO)
+	if err != nil {
+		return "", false, internal.ServerError(c, err, "Failed to validate request body")
+	}
+
+	if !result.IsValid {
+		return "", false, result.SendValidationError(c)
+	}
+
+	return codeDTO.Code, true, nil
+}
+
+func (s *Server) EnrollTOTP(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	user, err := s.Users.FetchUser(userID)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to fetch user data")
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to generate secret")
+	}
+
+	err = s.MFA.StartEnrollment(userID, secret)
+	if err != nil {
+		if errors.Is(err, models.ErrMFAAlreadyEnabled) {
+			return internal.ClientError(c, http.StatusConflict, internal.DefaultError{
+				Code:    "MFA_ALREADY_ENABLED",
+				Message: "Two-factor authentication is already enabled",
+			})
+		}
+
+		return internal.ServerError(c, err, "Failed to start two-factor authentication setup")
+	}
+
+	return c.JSON(map[string]string{
+		"secret": secret,
+		"uri":    totp.URI(totpIssuer, user.Email, secret),
+	})
+}
+
+func (s *Server) ConfirmTOTP(c *fiber.Ctx) error {
+	code, ok, err := parseMFACode(c)
+	if !ok {
+		return err
+	}
+
+	userID := c.Locals("userID").(string)
+
+	enrollment, err := s.MFA.FetchEnrollment(userID)
+	if err != nil {
+		if errors.Is(err, models.ErrMFANotEnrolled) {
+			return internal.ClientError(c, http.StatusBadRequest, internal.DefaultError{
+				Code:    "MFA_NOT_ENROLLED",
+				Message: "Two-factor authentication setup hasn't been started",
+			})
+		}
+
+		return internal.ServerError(c, err, "Failed to confirm two-factor authentication")
+	}
+
+	if enrollment.Enabled {
+		return internal.ClientError(c, http.StatusConflict, internal.DefaultError{
+			Code:    "MFA_ALREADY_ENABLED",
+			Message: "Two-factor authentication is already enabled",
+		})
+	}
+
+	step, ok := totp.Validate(enrollment.Secret, normalizeCode(code), time.Now())
+	if !ok {
+		return internal.ClientError(c, http.StatusBadRequest, errInvalidMFACode)
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to generate recovery codes")
+	}
+
+	err = s.MFA.Enable(userID, step, hashes)
+	if err != nil {
+		if errors.Is(err, models.ErrMFAAlreadyEnabled) {
+			return internal.ClientError(c, http.StatusConflict, internal.DefaultError{
+				Code:    "MFA_ALREADY_ENABLED",
+				Message: "Two-factor authentication is already enabled",
+			})
+		}
+
+		return internal.ServerError(c, err, "Failed to enable two-factor authentication")
+	}
+
+	return c.JSON(map[string]any{
+		"msg":           "Two-factor authentication enabled",
+		"recoveryCodes": codes,
+	})
+}
+
+func (s *Server) DisableTOTP(c *fiber.Ctx) error {
+	code, ok, err := parseMFACode(c)
+	if !ok {
+		return err
+	}
+
+	userID := c.Locals("userID").(string)
+
+	valid, ok, err := s.checkSecondFactor(c, userID, code)
+	if !ok {
+		return err
+	}
+
+	if !valid {
+		return internal.ClientError(c, http.StatusBadRequest, errInvalidMFACode)
+	}
+
+	err = s.MFA.Disable(userID)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to disable two-factor authentication")
+	}
+
+	return c.JSON(map[string]string{
+		"msg": "Two-factor authentication disabled",
+	})
+}
+
+func (s *Server) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	code, ok, err := parseMFACode(c)
+	if !ok {
+		return err
+	}
+
+	userID := c.Locals("userID").(string)
+
+	valid, ok, err := s.checkSecondFactor(c, userID, code)
+	if !ok {
+		return err
+	}
+
+	if !valid {
+		return internal.ClientError(c, http.StatusBadRequest, errInvalidMFACode)
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to generate recovery codes")
+	}
+
+	err = s.MFA.ReplaceRecoveryCodes(userID, hashes)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to generate recovery codes")
+	}
+
+	return c.JSON(map[string]any{
+		"msg":           "Recovery codes regenerated",
+		"recoveryCodes": codes,
+	})
+}
+
+// VerifyMFA completes a login that required a second factor by exchanging the challenge token
+// and a TOTP or recovery code for a session.
+func (s *Server) VerifyMFA(c *fiber.Ctx) error {
+	err := internal.VerifyContentType(c, "application/x-www-form-urlencoded")
+	if err != nil {
+		return internal.ClientError(c, http.StatusUnsupportedMediaType, internal.DefaultError{
+			Code:    "UNSUPPORTED_MEDIA_TYPE",
+			Message: "Content-Type header must be application/x-www-form-urlencoded",
+		})
+	}
+
+	var verifyDTO verifyMFADTO
+	err = c.BodyParser(&verifyDTO)
+	if err != nil {
+		return err
+	}
+
+	result, err := validator.Validate(verifyDTO)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to validate request body")
+	}
+
+	if !result.IsValid {
+		return result.SendValidationError(c)
+	}
+
+	challenge, err := s.MFA.AttemptChallenge(internal.HashToken(verifyDTO.MFAToken))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			return internal.ClientError(c, http.StatusUnauthorized, internal.DefaultError{
+				Code:    "INVALID_MFA_TOKEN",
+				Message: "The login attempt has expired, please log in again",
+			})
+		}
+
+		return internal.ServerError(c, err, "Failed to verify code")
+	}
+
+	// Every valid password gets a new challenge, so wrong codes are throttled per account like wrong passwords
+	valid, ok, err := s.checkSecondFactor(c, challenge.UserID, verifyDTO.Code)
+	if !ok {
+		return err
+	}
+
+	if !valid {
+		return internal.ClientError(c, http.StatusUnauthorized, errInvalidMFACode)
+	}
+
+	err = s.MFA.CompleteChallenge(challenge.ChallengeID)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to verify code")
+	}
+
+	return s.issueSession(c, challenge.UserID, challenge.Platform, challenge.OS, "login successful")
+}
@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/oidc"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/validator"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"golang.org/x/oauth2"
+)
+
+// Number of random suffixes tried when the username taken from the provider is already in use
+const maxUsernameAttempts = 5
+
+type oidcAuthorizeDTO struct {
+	Platform string `validate:"req"`
+	OS       string `validate:"req"`
+}
+
+type oidcCallbackDTO struct {
+	Code  string `validate:"req"`
+	State string `validate:"req"`
+}
+
+var errInvalidState = internal.DefaultError{
+	Code:    "INVALID_STATE",
+	Message: "The sign in request has expired or was already completed",
+}
+
+var errOIDCRejected = internal.DefaultError{
+	Code:    "OIDC_LOGIN_FAILED",
+	Message: "The provider could not confirm your identity",
+}
+
+var errAccountExists = internal.DefaultError{
+	Code:    "ACCOUNT_EXISTS",
+	Message: "An account with this email already exists, log in and link the provider from your account settings",
+}
+
+// providerParam returns the provider in the route, responding with an error if it isn't configured.
+func (s *Server) providerParam(c *fiber.Ctx) (string, bool, error) {
+	provider := c.Params("provider")
+	if !s.OIDC.Has(provider) {
+		return "", false, internal.ClientError(c, http.StatusNotFound, internal.DefaultError{
+			Code:    "PROVIDER_NOT_FOUND",
+			Message: "The sign in provider doesn't exist",
+		})
+	}
+
+	return provider, true, nil
+}
+
+// startAuthorization stores a new authorization request and responds with the URL the user must be sent to.
+func (s *Server) startAuthorization(c *fiber.Ctx, state models.OIDCState) error {
+	stateToken, err := internal.GenerateSecureToken()
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to start sign in")
+	}
+
+	state.Nonce, err = internal.GenerateSecureToken()
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to start sign in")
+	}
+
+	state.CodeVerifier = oauth2.GenerateVerifier()
+
+	authorizationURL, err := s.OIDC.AuthCodeURL(c.Context(), state.Provider, stateToken, state.Nonce, state.CodeVerifier)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to start sign in")
+	}
+
+	err = s.Identities.CreateState(internal.HashToken(stateToken), state)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to start sign in")
+	}
+
+	return c.JSON(map[string]string{
+		"authorizationURL": authorizationURL,
+	})
+}
+
+// finishAuthorization consumes the state the provider sent back and exchanges the code for the user's claims.
+func (s *Server) finishAuthorization(c *fiber.Ctx, provider string) (models.OIDCState, oidc.Claims, bool, error) {
+	err := internal.VerifyContentType(c, "application/x-www-form-urlencoded")
+	if err != nil {
+		return models.OIDCState{}, oidc.Claims{}, false, internal.ClientError(c, http.StatusUnsupportedMediaType, internal.DefaultError{
+			Code:    "UNSUPPORTED_MEDIA_TYPE",
+			Message: "Content-Type header must be application/x-www-form-urlencoded",
+		})
+	}
+
+	var callbackDTO oidcCallbackDTO
+	err = c.BodyParser(&callbackDTO)
+	if err != nil {
+		return models.OIDCState{}, oidc.Claims{}, false, err
+	}
+
+	result, err := validator.Validate(callbackDTO)
+	if err != nil {
+		return models.OIDCState{}, oidc.Claims{}, false, internal.ServerError(c, err, "Failed to validate request body")
+	}
+
+	if !result.IsValid {
+		return models.OIDCState{}, oidc.Claims{}, false, result.SendValidationError(c)
+	}
+
+	state, err := s.Identities.ConsumeState(internal.HashToken(callbackDTO.State), provider)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			return state, oidc.Claims{}, false, internal.ClientError(c, http.StatusBadRequest, errInvalidState)
+		}
+
+		return state, oidc.Claims{}, false, internal.ServerError(c, err, "Failed to complete sign in")
+	}
+
+	claims, err := s.OIDC.Exchange(c.Context(), provider, callbackDTO.Code, state.Nonce, state.CodeVerifier)
+	if err != nil {
+		log.Warnf("Rejected sign in with provider %s: %v", provider, err)
+		return state, claims, false, internal.ClientError(c, http.StatusUnauthorized, errOIDCRejected)
+	}
+
+	return state, claims, true, nil
+}
+
+// GetOIDCProviders lists the providers users can sign in with.
+func (s *Server) GetOIDCProviders(c *fiber.Ctx) error {
+	return c.JSON(s.OIDC.Names())
+}
+
+// BeginOIDCLogin responds with the URL of the provider's sign in page.
+// The provider redirects the user back to the web client, which then calls FinishOIDCLogin with the code and state.
+func (s *Server) BeginOIDCLogin(c *fiber.Ctx) error {
+	provider, ok, err := s.providerParam(c)
+	if !ok {
+		return err
+	}
+
+	err = internal.VerifyContentType(c, "application/x-www-form-urlencoded")
+	if err != nil {
+		return internal.ClientError(c, http.StatusUnsupportedMediaType, internal.DefaultError{
+			Code:    "UNSUPPORTED_MEDIA_TYPE",
+			Message: "Content-Type header must be application/x-www-form-urlencoded",
+		})
+	}
+
+	var authorizeDTO oidcAuthorizeDTO
+	err = c.BodyParser(&authorizeDTO)
+	if err != nil {
+		return err
+	}
+
+	result, err := validator.Validate(authorizeDTO)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to validate request body")
+	}
+
+	if !result.IsValid {
+		return result.SendValidationError(c)
+	}
+
+	return s.startAuthorization(c, models.OIDCState{
+		Provider: provider,
+		Platform: authorizeDTO.Platform,
+		OS:       authorizeDTO.OS,
+	})
+}
+
+// FinishOIDCLogin logs in the user the identity is linked to, creating an account on their first sign in.
+func (s *Server) FinishOIDCLogin(c *fiber.Ctx) error {
+	provider, ok, err := s.providerParam(c)
+	if !ok {
+		return err
+	}
+
+	state, claims, ok, err := s.finishAuthorization(c, provider)
+	if !ok {
+		return err
+	}
+
+	// States created to link an identity can't be used to log in
+	if state.UserID != "" {
+		return internal.ClientError(c, http.StatusBadRequest, errInvalidState)
+	}
+
+	userID, err := s.Identities.FetchUserID(provider, claims.Subject)
+	if err == nil {
+		return s.completeLogin(c, userID, state.Platform, state.OS)
+	}
+
+	if !errors.Is(err, models.ErrIdentityNotFound) {
+		return internal.ServerError(c, err, "Failed to complete sign in")
+	}
+
+	if claims.Email == "" {
+		return internal.ClientError(c, http.StatusUnprocessableEntity, internal.DefaultError{
+			Code:    "EMAIL_REQUIRED",
+			Message: "The provider didn't share an email address for this account",
+		})
+	}
+
+	// Linking automatically would let anyone with a provider account claiming the same email take over the account,
+	// the owner has to log in and link the provider themselves
+	_, err = s.Users.FetchUserByEmail(claims.Email)
+	if err == nil {
+		return internal.ClientError(c, http.StatusConflict, errAccountExists)
+	}
+
+	if !errors.Is(err, models.ErrUserNotFound) {
+		return internal.ServerError(c, err, "Failed to complete sign in")
+	}
+
+	userID, err = s.createOIDCUser(provider, claims)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			return internal.ClientError(c, http.StatusConflict, errAccountExists)
+		}
+
+		return internal.ServerError(c, err, "Failed to create user")
+	}
+
+	if !claims.EmailVerified {
+		s.queueVerificationEmail(userID, claims.Email)
+	}
+
+	return s.issueSession(c, userID, state.Platform, state.OS, "user created successfully")
+}
+
+// createOIDCUser creates an account linked to the provider for a user signing in with it for the first time.
+// The account gets a random password the user can replace through the password reset flow.
+func (s *Server) createOIDCUser(provider string, claims oidc.Claims) (string, error) {
+	password, err := internal.GenerateSecureToken()
+	if err != nil {
+		return "", err
+	}
+
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = sanitizeUsername(base)
+
+	username := base
+	for range maxUsernameAttempts {
+		userID, err := s.Identities.CreateUser(username, claims.Email, password, provider, claims.Subject, claims.EmailVerified)
+		if !errors.Is(err, models.ErrDuplicateUsername) {
+			return userID, err
+		}
+
+		username = fmt.Sprintf("%s_%04d", base, rand.IntN(10000))
+	}
+
+	return "", models.ErrDuplicateUsername
+}
+
+// sanitizeUsername keeps the characters of the name that are safe in a username,
+// leaving room for the suffix added when it's taken.
+func sanitizeUsername(name string) string {
+	var username strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '.' {
+			username.WriteRune(r)
+		}
+
+		if username.Len() == 25 {
+			break
+		}
+	}
+
+	if username.Len() == 0 {
+		return "user"
+	}
+
+	return username.String()
+}
+
+// BeginOIDCLink responds with the URL of the provider's sign in page to link an identity to the current user.
+func (s *Server) BeginOIDCLink(c *fiber.Ctx) error {
+	provider, ok, err := s.providerParam(c)
+	if !ok {
+		return err
+	}
+
+	return s.startAuthorization(c, models.OIDCState{
+		Provider: provider,
+		UserID:   c.Locals("userID").(string),
+	})
+}
+
+func (s *Server) FinishOIDCLink(c *fiber.Ctx) error {
+	provider, ok, err := s.providerParam(c)
+	if !ok {
+		return err
+	}
+
+	state, claims, ok, err := s.finishAuthorization(c, provider)
+	if !ok {
+		return err
+	}
+
+	userID := c.Locals("userID").(string)
+	if state.UserID != userID {
+		return internal.ClientError(c, http.StatusBadRequest, errInvalidState)
+	}
+
+	err = s.Identities.LinkIdentity(userID, provider, claims.Subject, claims.Email)
+	if err != nil {
+		if errors.Is(err, models.ErrIdentityLinked) {
+			return internal.ClientError(c, http.StatusConflict, internal.DefaultError{
+				Code:    "IDENTITY_LINKED",
+				Message: "This account is already linked to another user, or you already linked an account of this provider",
+			})
+		}
+
+		return internal.ServerError(c, err, "Failed to link account")
+	}
+
+	return c.JSON(map[string]string{
+		"msg": "Account linked",
+	})
+}
+
+func (s *Server) GetIdentities(c *fiber.Ctx) error {
+	identities, err := s.Identities.FetchIdentities(c.Locals("userID").(string))
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to fetch linked accounts")
+	}
+
+	return c.JSON(identities)
+}
+
+func (s *Server) UnlinkIdentity(c *fiber.Ctx) error {
+	err := s.Identities.UnlinkIdentity(c.Locals("userID").(string), c.Params("provider"))
+	if err != nil {
+		if errors.Is(err, models.ErrIdentityNotFound) {
+			return internal.ClientError(c, http.StatusNotFound, internal.DefaultError{
+				Code:    "IDENTITY_NOT_FOUND",
+				Message: "No account of this provider is linked",
+			})
+		}
+
+		if errors.Is(err, models.ErrLastLoginMethod) {
+			return internal.ClientError(c, http.StatusConflict, internal.DefaultError{
+				Code:    "LAST_LOGIN_METHOD",
+				Message: "This is the only way you can log in, set a password with \"Forgot password\" or register a passkey first",
+			})
+		}
+
+		return internal.ServerError(c, err, "Failed to unlink account")
+	}
+
+	return c.JSON(map[string]string{
+		"msg": "Account unlinked",
+	})
+}
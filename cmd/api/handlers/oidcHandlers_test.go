@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/auth"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/jwt"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/mailer"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/migrations"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/oidc"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/oidc/oidctest"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type oidcTest struct {
+	t      *testing.T
+	app    *fiber.App
+	server *Server
+	mock   *oidctest.Provider
+}
+
+type testResponse struct {
+	Status int
+	Body   map[string]any
+}
+
+// errorCode returns the code of the first error in the response, empty if it isn't an error.
+func (r testResponse) errorCode() string {
+	errs, _ := r.Body["errors"].([]any)
+	if len(errs) == 0 {
+		return ""
+	}
+
+	clientError, _ := errs[0].(map[string]any)
+	code, _ := clientError["code"].(string)
+	return code
+}
+
+// newOIDCTest runs the API against the mock provider, configured both as "mock" and "other".
+// It needs a Postgres database in TEST_DATABASE_URL and is skipped without one.
+func newOIDCTest(t *testing.T) *oidcTest {
+	t.Helper()
+
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	schema := "oidc_test_" + strings.ToLower(internal.GenerateID())
+
+	setupPool, err := pgxpool.New(context.Background(), databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(setupPool.Close)
+
+	_, err = setupPool.Exec(context.Background(), "CREATE SCHEMA "+schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		setupPool.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+	})
+
+	config, err := pgxpool.ParseConfig(databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	err = migrations.Apply(context.Background(), pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock, err := oidctest.NewProvider("iris", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mock.Close)
+
+	keys := jwt.NewKeyManager()
+	_, err = keys.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	providers := []oidc.ProviderConfig{}
+	for _, name := range []string{"mock", "other"} {
+		providers = append(providers, oidc.ProviderConfig{
+			Name:         name,
+			Issuer:       mock.Issuer(),
+			ClientID:     "iris",
+			ClientSecret: "secret",
+			RedirectURL:  "http://localhost:5173/oauth/callback/" + name,
+			Scopes:       []string{"openid", "email", "profile"},
+		})
+	}
+
+	sessions := &models.SessionsModel{DB: pool}
+	server := &Server{
+		DBpool:             pool,
+		Users:              &models.UserModel{DB: pool},
+		Sessions:           sessions,
+		EmailVerifications: &models.EmailVerificationModel{DB: pool},
+		MFA:                &models.MFAModel{DB: pool},
+		Mailer:             &mailer.LogMailer{Path: filepath.Join(t.TempDir(), "mail.log")},
+		Keys:               keys,
+		Verifier: &auth.Verifier{
+			Keys:        keys,
+			Revocations: auth.NewRevocationCache(sessions, time.Second),
+		},
+		Identities:       &models.IdentityModel{DB: pool},
+		AccountDeletions: &models.AccountDeletionModel{DB: pool},
+		OIDC:             oidc.NewProviders(providers...),
+		AppURL:           "http://localhost:5173",
+	}
+
+	app := fiber.New()
+	server.LoadRoutes(app)
+
+	return &oidcTest{t: t, app: app, server: server, mock: mock}
+}
+
+func (o *oidcTest) request(method, path, accessToken string, form url.Values) testResponse {
+	o.t.Helper()
+
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req := httptest.NewRequest(method, path, body)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	res, err := o.app.Test(req, 10_000)
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	response := testResponse{Status: res.StatusCode, Body: map[string]any{}}
+	json.NewDecoder(res.Body).Decode(&response.Body)
+
+	return response
+}
+
+// signIn starts an authorization and lets the mock provider sign user in, returning the code and state to send back.
+func (o *oidcTest) signIn(path, accessToken string, user oidctest.User) url.Values {
+	o.t.Helper()
+
+	res := o.request(http.MethodPost, path, accessToken, url.Values{"platform": {"web"}, "os": {"linux"}})
+	if res.Status != http.StatusOK {
+		o.t.Fatalf("POST %s returned %d: %v", path, res.Status, res.Body)
+	}
+
+	o.mock.SetUser(user)
+
+	authorizationURL, _ := res.Body["authorizationURL"].(string)
+	code, state, err := o.mock.Authorize(authorizationURL)
+	if err != nil {
+		o.t.Fatal(err)
+	}
+
+	return url.Values{"code": {code}, "state": {state}}
+}
+
+// login signs in through the provider and returns the user the API logged in.
+func (o *oidcTest) login(user oidctest.User) testResponse {
+	o.t.Helper()
+
+	callback := o.signIn("/auth/oidc/mock/authorize", "", user)
+	return o.request(http.MethodPost, "/auth/oidc/mock/callback", "", callback)
+}
+
+// newUser creates a user with a password and returns it with an access token of a new session.
+func (o *oidcTest) newUser(username, email string) (string, string) {
+	o.t.Helper()
+
+	userID, err := o.server.Users.InsertUser(username, email, "password123")
+	if err != nil {
+		o.t.Fatal(err)
+	}
+
+	session, err := o.server.Sessions.NewSession(userID, "web", "linux", "127.0.0.1")
+	if err != nil {
+		o.t.Fatal(err)
+	}
+
+	accessToken, err := o.server.Keys.GenerateAccessToken(userID, session.SessionID)
+	if err != nil {
+		o.t.Fatal(err)
+	}
+
+	return userID, accessToken
+}
+
+func responseUserID(res testResponse) string {
+	user, _ := res.Body["user"].(map[string]any)
+	userID, _ := user["userID"].(string)
+	return userID
+}
+
+func TestOIDCLoginCreatesAccount(t *testing.T) {
+	o := newOIDCTest(t)
+	user := oidctest.User{Subject: "subject-1", Email: "ada@example.com", EmailVerified: true, PreferredUsername: "Ada Lovelace"}
+
+	res := o.login(user)
+	if res.Status != http.StatusOK {
+		t.Fatalf("first login returned %d: %v", res.Status, res.Body)
+	}
+
+	userID := responseUserID(res)
+	created, err := o.server.Users.FetchUser(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if created.Email != user.Email || created.Username != "adalovelace" || !created.Verified {
+		t.Errorf("created user = %+v", created)
+	}
+
+	// Signing in again logs into the same account
+	res = o.login(user)
+	if res.Status != http.StatusOK || responseUserID(res) != userID {
+		t.Errorf("second login returned %d for user %q, want user %q", res.Status, responseUserID(res), userID)
+	}
+}
+
+func TestOIDCLoginRefusesExistingEmail(t *testing.T) {
+	o := newOIDCTest(t)
+	o.newUser("ada", "ada@example.com")
+
+	res := o.login(oidctest.User{Subject: "subject-1", Email: "ada@example.com", EmailVerified: true})
+	if res.Status != http.StatusConflict || res.errorCode() != "ACCOUNT_EXISTS" {
+		t.Errorf("login returned %d %q, want 409 ACCOUNT_EXISTS", res.Status, res.errorCode())
+	}
+}
+
+func TestOIDCState(t *testing.T) {
+	o := newOIDCTest(t)
+	user := oidctest.User{Subject: "subject-1", Email: "ada@example.com", EmailVerified: true}
+
+	t.Run("single use", func(t *testing.T) {
+		callback := o.signIn("/auth/oidc/mock/authorize", "", user)
+
+		res := o.request(http.MethodPost, "/auth/oidc/mock/callback", "", callback)
+		if res.Status != http.StatusOK {
+			t.Fatalf("login returned %d: %v", res.Status, res.Body)
+		}
+
+		res = o.request(http.MethodPost, "/auth/oidc/mock/callback", "", callback)
+		if res.Status != http.StatusBadRequest || res.errorCode() != "INVALID_STATE" {
+			t.Errorf("replayed state returned %d %q, want 400 INVALID_STATE", res.Status, res.errorCode())
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		callback := o.signIn("/auth/oidc/mock/authorize", "", user)
+		callback.Set("state", "forged")
+
+		res := o.request(http.MethodPost, "/auth/oidc/mock/callback", "", callback)
+		if res.Status != http.StatusBadRequest || res.errorCode() != "INVALID_STATE" {
+			t.Errorf("forged state returned %d %q, want 400 INVALID_STATE", res.Status, res.errorCode())
+		}
+	})
+
+	t.Run("other provider", func(t *testing.T) {
+		callback := o.signIn("/auth/oidc/mock/authorize", "", user)
+
+		res := o.request(http.MethodPost, "/auth/oidc/other/callback", "", callback)
+		if res.Status != http.StatusBadRequest || res.errorCode() != "INVALID_STATE" {
+			t.Errorf("state of another provider returned %d %q, want 400 INVALID_STATE", res.Status, res.errorCode())
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		callback := o.signIn("/auth/oidc/mock/authorize", "", user)
+
+		_, err := o.server.DBpool.Exec(context.Background(), "UPDATE oidcStates SET expiresAt = NOW() - INTERVAL '1 second'")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res := o.request(http.MethodPost, "/auth/oidc/mock/callback", "", callback)
+		if res.Status != http.StatusBadRequest || res.errorCode() != "INVALID_STATE" {
+			t.Errorf("expired state returned %d %q, want 400 INVALID_STATE", res.Status, res.errorCode())
+		}
+	})
+
+	t.Run("link state used to log in", func(t *testing.T) {
+		_, accessToken := o.newUser("grace", "grace@example.com")
+		callback := o.signIn("/auth/oidc/mock/link", accessToken, user)
+
+		res := o.request(http.MethodPost, "/auth/oidc/mock/callback", "", callback)
+		if res.Status != http.StatusBadRequest || res.errorCode() != "INVALID_STATE" {
+			t.Errorf("link state returned %d %q at the login callback, want 400 INVALID_STATE", res.Status, res.errorCode())
+		}
+	})
+}
+
+func TestOIDCLinkAndUnlink(t *testing.T) {
+	o := newOIDCTest(t)
+	user := oidctest.User{Subject: "subject-1", Email: "ada@example.com", EmailVerified: true}
+	userID, accessToken := o.newUser("ada", "ada@example.com")
+	_, otherAccessToken := o.newUser("grace", "grace@example.com")
+
+	// Another user can't finish a link started by someone else
+	callback := o.signIn("/auth/oidc/mock/link", accessToken, user)
+	res := o.request(http.MethodPost, "/auth/oidc/mock/link/callback", otherAccessToken, callback)
+	if res.Status != http.StatusBadRequest || res.errorCode() != "INVALID_STATE" {
+		t.Errorf("linking with another user's state returned %d %q, want 400 INVALID_STATE", res.Status, res.errorCode())
+	}
+
+	// Login states can't link an identity
+	callback = o.signIn("/auth/oidc/mock/authorize", "", user)
+	res = o.request(http.MethodPost, "/auth/oidc/mock/link/callback", accessToken, callback)
+	if res.Status != http.StatusBadRequest || res.errorCode() != "INVALID_STATE" {
+		t.Errorf("linking with a login state returned %d %q, want 400 INVALID_STATE", res.Status, res.errorCode())
+	}
+
+	callback = o.signIn("/auth/oidc/mock/link", accessToken, user)
+	res = o.request(http.MethodPost, "/auth/oidc/mock/link/callback", accessToken, callback)
+	if res.Status != http.StatusOK {
+		t.Fatalf("linking returned %d: %v", res.Status, res.Body)
+	}
+
+	identities, err := o.server.Identities.FetchIdentities(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 1 || identities[0].Provider != "mock" || identities[0].Email != user.Email {
+		t.Errorf("identities = %+v, want the mock identity", identities)
+	}
+
+	// The linked identity logs into the account instead of creating one
+	res = o.login(user)
+	if res.Status != http.StatusOK || responseUserID(res) != userID {
+		t.Errorf("login returned %d for user %q, want user %q", res.Status, responseUserID(res), userID)
+	}
+
+	// The identity belongs to the first user now
+	callback = o.signIn("/auth/oidc/mock/link", otherAccessToken, user)
+	res = o.request(http.MethodPost, "/auth/oidc/mock/link/callback", otherAccessToken, callback)
+	if res.Status != http.StatusConflict || res.errorCode() != "IDENTITY_LINKED" {
+		t.Errorf("linking an identity of another user returned %d %q, want 409 IDENTITY_LINKED", res.Status, res.errorCode())
+	}
+
+	res = o.request(http.MethodDelete, "/auth/identities/mock", accessToken, nil)
+	if res.Status != http.StatusOK {
+		t.Fatalf("unlinking returned %d: %v", res.Status, res.Body)
+	}
+
+	res = o.request(http.MethodDelete, "/auth/identities/mock", accessToken, nil)
+	if res.Status != http.StatusNotFound || res.errorCode() != "IDENTITY_NOT_FOUND" {
+		t.Errorf("unlinking twice returned %d %q, want 404 IDENTITY_NOT_FOUND", res.Status, res.errorCode())
+	}
+
+	// Once unlinked the identity no longer logs in, and the email is still taken by the account
+	res = o.login(user)
+	if res.Status != http.StatusConflict || res.errorCode() != "ACCOUNT_EXISTS" {
+		t.Errorf("login after unlinking returned %d %q, want 409 ACCOUNT_EXISTS", res.Status, res.errorCode())
+	}
+}
+
+func TestOIDCUnlinkLastLoginMethod(t *testing.T) {
+	o := newOIDCTest(t)
+	user := oidctest.User{Subject: "subject-1", Email: "ada@example.com", EmailVerified: true}
+
+	res := o.login(user)
+	if res.Status != http.StatusOK {
+		t.Fatalf("login returned %d: %v", res.Status, res.Body)
+	}
+	tokens, _ := res.Body["tokens"].(map[string]any)
+	accessToken, _ := tokens["accessToken"].(string)
+
+	// The account was created with a password the user doesn't know
+	res = o.request(http.MethodDelete, "/auth/identities/mock", accessToken, nil)
+	if res.Status != http.StatusConflict || res.errorCode() != "LAST_LOGIN_METHOD" {
+		t.Errorf("unlinking the only login method returned %d %q, want 409 LAST_LOGIN_METHOD", res.Status, res.errorCode())
+	}
+
+	res = o.login(user)
+	if res.Status != http.StatusOK {
+		t.Errorf("login after refused unlink returned %d: %v", res.Status, res.Body)
+	}
+}
@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/validator"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+type passkeyLoginDTO struct {
+	Platform string `validate:"req"`
+	OS       string `validate:"req"`
+}
+
+// passkeyUser adapts a user to the interface the WebAuthn library expects.
+// The user handle stored by authenticators is the user's ID.
+type passkeyUser struct {
+	user        models.UserDTO
+	credentials []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte {
+	return []byte(u.user.UserID)
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	return u.user.Username
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	if u.user.DisplayName != "" {
+		return string(u.user.DisplayName)
+	}
+
+	return u.user.Username
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+var errInvalidCeremony = internal.DefaultError{
+	Code:    "INVALID_CEREMONY",
+	Message: "The passkey request has expired or was already completed",
+}
+
+var errPasskeyRejected = internal.DefaultError{
+	Code:    "PASSKEY_REJECTED",
+	Message: "The passkey could not be verified",
+}
+
+func (s *Server) fetchPasskeyUser(userID string) (*passkeyUser, error) {
+	user, err := s.Users.FetchUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := s.Passkeys.FetchCredentials(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &passkeyUser{user: user, credentials: credentials}, nil
+}
+
+func (s *Server) BeginPasskeyRegistration(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	user, err := s.fetchPasskeyUser(userID)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to fetch user data")
+	}
+
+	// Don't let the user register the same authenticator twice
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.credentials))
+	for _, credential := range user.credentials {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	options, sessionData, err := s.WebAuthn.BeginRegistration(user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to start passkey registration")
+	}
+
+	ceremonyID, err := s.Passkeys.CreateCeremony(models.CeremonyRegistration, userID, "", "", *sessionData)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to start passkey registration")
+	}
+
+	return c.JSON(map[string]any{
+		"ceremonyID": ceremonyID,
+		"options":    options,
+	})
+}
+
+// FinishPasskeyRegistration expects the JSON serialized PublicKeyCredential returned by navigator.credentials.create().
+func (s *Server) FinishPasskeyRegistration(c *fiber.Ctx) error {
+	err := internal.VerifyContentType(c, "application/json")
+	if err != nil {
+		return internal.ClientError(c, http.StatusUnsupportedMediaType, internal.DefaultError{
+			Code:    "UNSUPPORTED_MEDIA_TYPE",
+			Message: "Content-Type header must be application/json",
+		})
+	}
+
+	userID := c.Locals("userID").(string)
+
+	name := c.Query("name", "Passkey")
+	if len(name) > 100 {
+		return internal.ClientError(c, http.StatusBadRequest, internal.DefaultError{
+			Code:    "INVALID_NAME",
+			Message: "Passkey names must be less than 100 characters long",
+		})
+	}
+
+	ceremony, err := s.Passkeys.ConsumeCeremony(c.Params("ceremonyID"), models.CeremonyRegistration)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			return internal.ClientError(c, http.StatusBadRequest, errInvalidCeremony)
+		}
+
+		return internal.ServerError(c, err, "Failed to register passkey")
+	}
+
+	if ceremony.UserID != userID {
+		return internal.ClientError(c, http.StatusBadRequest, errInvalidCeremony)
+	}
+
+	response, err := protocol.ParseCredentialCreationResponseBytes(c.Body())
+	if err != nil {
+		return internal.ClientError(c, http.StatusBadRequest, errPasskeyRejected)
+	}
+
+	user, err := s.fetchPasskeyUser(userID)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to fetch user data")
+	}
+
+	credential, err := s.WebAuthn.CreateCredential(user, ceremony.Data, response)
+	if err != nil {
+		return internal.ClientError(c, http.StatusBadRequest, errPasskeyRejected)
+	}
+
+	passkey, err := s.Passkeys.InsertCredential(userID, name, *credential)
+	if err != nil {
+		if errors.Is(err, models.ErrPasskeyExists) {
+			return internal.ClientError(c, http.StatusConflict, internal.DefaultError{
+				Code:    "PASSKEY_EXISTS",
+				Message: "This passkey is already registered",
+			})
+		}
+
+		return internal.ServerError(c, err, "Failed to register passkey")
+	}
+
+	return c.Status(http.StatusCreated).JSON(passkey)
+}
+
+func (s *Server) GetPasskeys(c *fiber.Ctx) error {
+	passkeys, err := s.Passkeys.FetchPasskeys(c.Locals("userID").(string))
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to fetch passkeys")
+	}
+
+	return c.JSON(passkeys)
+}
+
+func (s *Server) DeletePasskey(c *fiber.Ctx) error {
+	passkeyNotFound := internal.DefaultError{
+		Code:    "PASSKEY_NOT_FOUND",
+		Message: "Passkey not found",
+	}
+
+	credentialID, err := base64.RawURLEncoding.DecodeString(c.Params("credentialID"))
+	if err != nil {
+		return internal.ClientError(c, http.StatusNotFound, passkeyNotFound)
+	}
+
+	err = s.Passkeys.DeleteCredential(c.Locals("userID").(string), credentialID)
+	if err != nil {
+		if errors.Is(err, models.ErrPasskeyNotFound) {
+			return internal.ClientError(c, http.StatusNotFound, passkeyNotFound)
+		}
+
+		return internal.ServerError(c, err, "Failed to delete passkey")
+	}
+
+	return c.JSON(map[string]string{
+		"msg": "Passkey deleted",
+	})
+}
+
+// BeginPasskeyLogin starts a discoverable login, the authenticator tells us who the user is.
+func (s *Server) BeginPasskeyLogin(c *fiber.Ctx) error {
+	err := internal.VerifyContentType(c, "application/x-www-form-urlencoded")
+	if err != nil {
+		return internal.ClientError(c, http.StatusUnsupportedMediaType, internal.DefaultError{
+			Code:    "UNSUPPORTED_MEDIA_TYPE",
+			Message: "Content-Type header must be application/x-www-form-urlencoded",
+		})
+	}
+
+	var loginDTO passkeyLoginDTO
+	err = c.BodyParser(&loginDTO)
+	if err != nil {
+		return err
+	}
+
+	result, err := validator.Validate(loginDTO)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to validate request body")
+	}
+
+	if !result.IsValid {
+		return result.SendValidationError(c)
+	}
+
+	options, sessionData, err := s.WebAuthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to start passkey login")
+	}
+
+	ceremonyID, err := s.Passkeys.CreateCeremony(models.CeremonyLogin, "", loginDTO.Platform, loginDTO.OS, *sessionData)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to start passkey login")
+	}
+
+	return c.JSON(map[string]any{
+		"ceremonyID": ceremonyID,
+		"options":    options,
+	})
+}
+
+// FinishPasskeyLogin expects the JSON serialized PublicKeyCredential returned by navigator.credentials.get().
+// Passkeys verify the user on the device, so no second factor is asked for.
+func (s *Server) FinishPasskeyLogin(c *fiber.Ctx) error {
+	err := internal.VerifyContentType(c, "application/json")
+	if err != nil {
+		return internal.ClientError(c, http.StatusUnsupportedMediaType, internal.DefaultError{
+			Code:    "UNSUPPORTED_MEDIA_TYPE",
+			Message: "Content-Type header must be application/json",
+		})
+	}
+
+	ceremony, err := s.Passkeys.ConsumeCeremony(c.Params("ceremonyID"), models.CeremonyLogin)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			return internal.ClientError(c, http.StatusBadRequest, errInvalidCeremony)
+		}
+
+		return internal.ServerError(c, err, "Failed to log in with passkey")
+	}
+
+	response, err := protocol.ParseCredentialRequestResponseBytes(c.Body())
+	if err != nil {
+		return internal.ClientError(c, http.StatusUnauthorized, errPasskeyRejected)
+	}
+
+	user, credential, err := s.WebAuthn.ValidatePasskeyLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		return s.fetchPasskeyUser(string(userHandle))
+	}, ceremony.Data, response)
+	if err != nil {
+		return internal.ClientError(c, http.StatusUnauthorized, errPasskeyRejected)
+	}
+
+	userID := string(user.WebAuthnID())
+
+	// A counter that didn't move forward means the private key may have been copied to another device
+	if credential.Authenticator.CloneWarning {
+		log.Warnf("Rejected passkey login for user %s, the authenticator may have been cloned", userID)
+		return internal.ClientError(c, http.StatusUnauthorized, errPasskeyRejected)
+	}
+
+	err = s.Passkeys.UpdateAfterLogin(*credential)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to log in with passkey")
+	}
+
+	return s.issueSession(c, userID, ceremony.Platform, ceremony.OS, "login successful")
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/mailer"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/validator"
+	"github.com/gofiber/fiber/v2"
+)
+
+type resetPasswordDTO struct {
+	Token    string `validate:"req"`
+	Password string `validate:"min=8,max=50,req"`
+}
+
+func (s *Server) passwordResetLink() emailLink {
+	return emailLink{
+		name:     "password reset",
+		tokens:   s.PasswordResets,
+		path:     "/reset-password",
+		template: mailer.PasswordResetEmail,
+	}
+}
+
+func (s *Server) ForgotPassword(c *fiber.Ctx) error {
+	return s.requestEmailLink(c, s.passwordResetLink(), "If an account exists for this email, a password reset link has been sent to it")
+}
+
+func (s *Server) ResetPassword(c *fiber.Ctx) error {
+	err := internal.VerifyContentType(c, "application/x-www-form-urlencoded")
+	if err != nil {
+		return internal.ClientError(c, http.StatusUnsupportedMediaType, internal.DefaultError{
+			Code:    "UNSUPPORTED_MEDIA_TYPE",
+			Message: "Content-Type header must be application/x-www-form-urlencoded",
+		})
+	}
+
+	var resetDTO resetPasswordDTO
+	err = c.BodyParser(&resetDTO)
+	if err != nil {
+		return err
+	}
+
+	result, err := validator.Validate(resetDTO)
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to validate request body")
+	}
+
+	if !result.IsValid {
+		return result.SendValidationError(c)
+	}
+
+	userID, err := s.PasswordResets.ResetPassword(internal.HashToken(resetDTO.Token), resetDTO.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			return internal.ClientError(c, http.StatusBadRequest, internal.DefaultError{
+				Code:    "INVALID_TOKEN",
+				Message: "The reset link is invalid or has expired",
+			})
+		}
+
+		return internal.ServerError(c, err, "Failed to reset password")
+	}
+
+	// The sessions were ended along with the password change, disconnect them too
+	s.Websocket.CloseUserSessions(userID)
+
+	return c.JSON(map[string]string{
+		"msg": "Password reset successfully",
+	})
+}
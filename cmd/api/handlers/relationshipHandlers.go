@@ -54,7 +54,7 @@ func (s *Server) CreateRelationship(c *fiber.Ctx) error {
 	clientID := c.Locals("userID").(string)
 	recipientID := c.Params("userID")
 
-	res, err := s.Relationships.SetRelationship(clientID, recipientID)
+	res, channel, err := s.Relationships.SetRelationship(clientID, recipientID)
 	if err != nil {
 		// Check if the error is a constraint error
 		if errors.Is(err, models.ErrUserNotFound) {
@@ -100,13 +100,22 @@ func (s *Server) CreateRelationship(c *fiber.Ctx) error {
 		if res == "REQUEST_ACCEPTED" {
 			message.Type = "ACCEPTED"
 			s.Websocket.UpdateFriendStatus(recipientID, message)
+
+			// Let both users open the new conversation right away
+			s.Websocket.SendChannelCreate(recipientID, channel)
+			s.Websocket.SendChannelCreate(clientID, channel)
 		} else if res == "REQUEST_SENT" {
 			message.Type = "REQUEST"
 			s.Websocket.UpdateFriendStatus(recipientID, message)
 		}
 	}()
 
-	// TODO: Create new channel if one doesn't exist
+	if res == "REQUEST_ACCEPTED" {
+		return c.JSON(map[string]any{
+			"message": "Friend request accepted",
+			"channel": channel,
+		})
+	}
 
 	return c.JSON(map[string]string{
 		"message": "Friend request sent",
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+func (s *Server) GetSessions(c *fiber.Ctx) error {
+	currentSessionID := c.Locals("sessionID").(string)
+
+	sessions, err := s.Sessions.FetchSessions(c.Locals("userID").(string))
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to fetch sessions")
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].SessionID == currentSessionID
+	}
+
+	return c.JSON(sessions)
+}
+
+// RevokeSession logs out one of the user's devices. Revoking the current session is the same as logging out.
+func (s *Server) RevokeSession(c *fiber.Ctx) error {
+	sessionID := c.Params("sessionID")
+
+	err := s.Sessions.DeleteUserSession(c.Locals("userID").(string), sessionID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoSessionsFound) {
+			return internal.ClientError(c, http.StatusNotFound, internal.DefaultError{
+				Code:    "SESSION_NOT_FOUND",
+				Message: "No session found",
+			})
+		}
+
+		return internal.ServerError(c, err, "Failed to revoke session")
+	}
+
+	s.Websocket.CloseSession(sessionID)
+
+	return c.JSON(map[string]string{
+		"msg": "Session revoked",
+	})
+}
+
+// RevokeOtherSessions logs out every device except the one making the request.
+func (s *Server) RevokeOtherSessions(c *fiber.Ctx) error {
+	sessionIDs, err := s.Sessions.DeleteOtherSessions(c.Locals("userID").(string), c.Locals("sessionID").(string))
+	if err != nil {
+		return internal.ServerError(c, err, "Failed to revoke sessions")
+	}
+
+	for _, sessionID := range sessionIDs {
+		s.Websocket.CloseSession(sessionID)
+	}
+
+	return c.JSON(map[string]any{
+		"msg":     "Sessions revoked",
+		"revoked": len(sessionIDs),
+	})
+}
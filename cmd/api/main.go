@@ -5,17 +5,26 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/CDavidSV/Iris-Chat-App-Backend/cmd/api/handlers"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/auth"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/jwt"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/mailer"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/migrations"
 	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/oidc"
 	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/websocket"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/compress"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
+	"github.com/valyala/fasthttp"
 )
 
 func connectDB(connString string) (*pgxpool.Pool, error) {
@@ -43,6 +52,15 @@ func main() {
 	}
 	app := fiber.New(fiberConfig)
 
+	// data export archives are streamed from the database and can take much longer to download than WriteTimeout
+	app.Server().HeaderReceived = func(header *fasthttp.RequestHeader) fasthttp.RequestConfig {
+		if strings.HasPrefix(string(header.RequestURI()), "/exports/") {
+			return fasthttp.RequestConfig{WriteTimeout: time.Hour}
+		}
+
+		return fasthttp.RequestConfig{}
+	}
+
 	pool, err := connectDB(os.Getenv("DATABASE_URL"))
 	if err != nil {
 		log.Fatal("Failed to connect to database: ", err)
@@ -50,6 +68,12 @@ func main() {
 	fmt.Println("Successfully connected to postgres!")
 	defer pool.Close()
 
+	// bring the schema up to date before anything uses it
+	err = migrations.Apply(context.Background(), pool)
+	if err != nil {
+		log.Fatal("Failed to migrate database: ", err)
+	}
+
 	// setup logger
 	app.Use(logger.New(logger.Config{
 		TimeFormat: "02/06/2006 15:04:05",
@@ -67,23 +91,147 @@ func main() {
 	}))
 
 	// websockets
+	var eventBus websocket.EventBus = websocket.NewInMemoryBus()
+	if os.Getenv("EVENT_BUS") == "postgres" {
+		// Fan gateway events out to every replica of the API
+		pgBus := websocket.NewPostgresBus(pool, "gateway_events")
+		go pgBus.Listen(context.Background())
+		eventBus = pgBus
+	}
+
+	// access token signing keys, loaded from JWT_KEYS_DIR in production
+	var keys *jwt.KeyManager
+	if os.Getenv("JWT_KEYS_DIR") != "" {
+		keys, err = jwt.LoadKeyManager(os.Getenv("JWT_KEYS_DIR"), os.Getenv("JWT_ACTIVE_KEY"))
+		if err != nil {
+			log.Fatal("Failed to load JWT signing keys: ", err)
+		}
+	} else {
+		keys = jwt.NewKeyManager()
+		kid, err := keys.GenerateSigningKey()
+		if err != nil {
+			log.Fatal("Failed to generate JWT signing key: ", err)
+		}
+		fmt.Printf("JWT_KEYS_DIR is not set, signing access tokens with temporary key %s\n", kid)
+	}
+
+	// access tokens of revoked sessions are rejected, lookups are cached for a few seconds
+	verifier := &auth.Verifier{
+		Keys:        keys,
+		Revocations: auth.NewRevocationCache(&models.SessionsModel{DB: pool}, 30*time.Second),
+	}
+
 	websocketServer := websocket.WebsocketServer{
-		DB:                pool,
-		AccessTokenSecret: os.Getenv("ACCESS_TOKEN_SECRET"),
+		Connections:   websocket.NewHub(),
+		Events:        eventBus,
+		DB:            pool,
+		Users:         &models.UserModel{DB: pool},
+		Relationships: &models.RelationshipModel{DB: pool},
+		Channels:      &models.ChannelModel{DB: pool},
+		Presences:     &models.PresenceModel{DB: pool},
+		Verifier:      verifier,
+		InstanceID:    internal.GenerateID(),
 	}
+	websocketServer.SubscribeEvents()
+	go websocketServer.RefreshPresences(context.Background(), models.PresenceTTL/3)
 	app.Use("/ws", websocketServer.WebsocketUpgrade)
 	app.Get("/ws", websocketServer.NewWebsocket())
 
+	// email, written to a log file unless an SMTP server is configured
+	var mail mailer.Mailer = &mailer.LogMailer{Path: os.Getenv("MAIL_LOG_PATH")}
+	if os.Getenv("SMTP_HOST") != "" {
+		mail = &mailer.SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		}
+	}
+
+	// passkeys, the relying party is the web client
+	rpOrigins := strings.Split(os.Getenv("WEBAUTHN_RP_ORIGINS"), ",")
+	if os.Getenv("WEBAUTHN_RP_ORIGINS") == "" {
+		rpOrigins = []string{"http://localhost:5173"}
+	}
+
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = "localhost"
+	}
+
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: "Iris",
+		RPOrigins:     rpOrigins,
+	})
+	if err != nil {
+		log.Fatal("Failed to configure WebAuthn: ", err)
+	}
+
+	// base URL of the web client, APP_URL, used in the links sent by email
+	appURL := os.Getenv("APP_URL")
+	if appURL == "" {
+		appURL = "http://localhost:5173"
+	}
+
+	// base URL of the API itself, API_URL, used in links to files it serves
+	apiURL := os.Getenv("API_URL")
+	if apiURL == "" {
+		apiURL = "http://localhost:3000"
+	}
+
+	// external sign in providers, listed in OIDC_PROVIDERS, redirect back to the web client
+	oidcProviders, err := oidc.LoadProviders(appURL)
+	if err != nil {
+		log.Fatal("Failed to configure OpenID Connect providers: ", err)
+	}
+
 	server := &handlers.Server{
 		DBpool:        pool,
 		Users:         &models.UserModel{DB: pool},
 		Sessions:      &models.SessionsModel{DB: pool},
 		Relationships: &models.RelationshipModel{DB: pool},
 		Channels:      &models.ChannelModel{DB: pool},
+		Messages:      &models.MessageModel{DB: pool},
+
+		EmailVerifications: &models.EmailVerificationModel{DB: pool},
+		PasswordResets:     &models.PasswordResetModel{DB: pool},
+		MagicLinks:         &models.MagicLinkModel{DB: pool},
+		MFA:                &models.MFAModel{DB: pool},
+		Passkeys:           &models.PasskeyModel{DB: pool},
+		Mailer:             mail,
+		WebAuthn:           webAuthn,
+		Keys:               keys,
+		Verifier:           verifier,
+		LoginThrottle:      &auth.LoginThrottle{Attempts: &models.LoginAttemptModel{DB: pool}},
+		Identities:         &models.IdentityModel{DB: pool},
+		AccountDeletions:   &models.AccountDeletionModel{DB: pool},
+		DataExports:        &models.DataExportModel{DB: pool},
+		OIDC:               oidcProviders,
+		DataExportRequests: make(chan struct{}, 1),
+		AppURL:             appURL,
+		APIURL:             apiURL,
 
 		Websocket: &websocketServer,
 	}
 
+	// deleted accounts are purged once their grace period, ACCOUNT_DELETION_GRACE_PERIOD, is over
+	if os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD") != "" {
+		models.AccountDeletionGracePeriod, err = time.ParseDuration(os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD"))
+		if err != nil {
+			log.Fatal("Invalid ACCOUNT_DELETION_GRACE_PERIOD: ", err)
+		}
+	}
+	go server.PurgeDeletedAccounts(context.Background(), time.Hour)
+
+	// login attempts are only kept as long as the throttle needs them
+	go server.PruneLoginAttempts(context.Background(), time.Hour)
+
+	// data exports are built one at a time as soon as they're requested, the worker also resumes unfinished ones
+	// and deletes old archives
+	go server.ProcessDataExports(context.Background(), 5*time.Minute)
+
 	// load routes
 	server.LoadRoutes(app)
 
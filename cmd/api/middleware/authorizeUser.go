@@ -3,51 +3,52 @@ package middleware
 import (
 	"errors"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/auth"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/jwt"
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
 )
 
-func Authorize(c *fiber.Ctx) error {
-	headers := c.GetReqHeaders()
-	authorizationValue, ok := headers["Authorization"]
-	if !ok || len(authorizationValue) == 0 {
-		return c.SendStatus(http.StatusUnauthorized)
-	}
-
-	if !strings.HasPrefix(authorizationValue[0], "Bearer") {
-		return c.SendStatus(http.StatusUnauthorized)
-	}
-
-	accessToken := strings.Split(authorizationValue[0], " ")[1]
+// Authorize validates the access token and rejects tokens whose session has been revoked.
+func Authorize(verifier *auth.Verifier) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		headers := c.GetReqHeaders()
+		authorizationValue, ok := headers["Authorization"]
+		if !ok || len(authorizationValue) == 0 {
+			return c.SendStatus(http.StatusUnauthorized)
+		}
 
-	accessTokenSecret := os.Getenv("ACCESS_TOKEN_SECRET")
-	claims := jwt.MapClaims{}
-	token, err := jwt.ParseWithClaims(accessToken, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(accessTokenSecret), nil
-	})
+		if !strings.HasPrefix(authorizationValue[0], "Bearer ") {
+			return c.SendStatus(http.StatusUnauthorized)
+		}
 
-	if err != nil {
-		if errors.Is(err, jwt.ErrTokenExpired) {
-			return internal.ClientError(c, http.StatusUnauthorized, internal.DefaultError{
-				Code:    "TOKEN_EXPIRED",
-				Message: "Access token has expired",
-			})
+		accessToken := strings.TrimPrefix(authorizationValue[0], "Bearer ")
+
+		claims, err := verifier.VerifyAccessToken(accessToken)
+		if err != nil {
+			switch {
+			case errors.Is(err, jwt.ErrTokenExpired):
+				return internal.ClientError(c, http.StatusUnauthorized, internal.DefaultError{
+					Code:    "TOKEN_EXPIRED",
+					Message: "Access token has expired",
+				})
+			case errors.Is(err, auth.ErrSessionRevoked):
+				return internal.ClientError(c, http.StatusUnauthorized, internal.DefaultError{
+					Code:    "SESSION_REVOKED",
+					Message: "The session has ended, please log in again",
+				})
+			case errors.Is(err, auth.ErrInvalidToken):
+				return c.SendStatus(http.StatusUnauthorized)
+			default:
+				return internal.ServerError(c, err, "Failed to verify session")
+			}
 		}
 
-		return c.SendStatus(http.StatusUnauthorized)
-	}
+		c.Locals("userID", claims.UserID)
+		c.Locals("sessionID", claims.SessionID)
 
-	if !token.Valid {
-		return c.SendStatus(http.StatusUnauthorized)
+		return c.Next()
 	}
-
-	c.Locals("userID", claims["userID"])
-	c.Locals("sessionID", claims["sessionID"])
-	c.Locals("accessToken", token)
-
-	return c.Next()
 }
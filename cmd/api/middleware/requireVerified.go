@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireVerified rejects users that haven't verified their email yet. It must run after Authorize.
+func RequireVerified(users *models.UserModel) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, _ := c.Locals("userID").(string)
+
+		verified, err := users.IsVerified(userID)
+		if err != nil {
+			return internal.ServerError(c, err, "Failed to verify user")
+		}
+
+		if !verified {
+			return internal.ClientError(c, http.StatusForbidden, internal.DefaultError{
+				Code:    "EMAIL_NOT_VERIFIED",
+				Message: "You must verify your email to perform this action",
+			})
+		}
+
+		return c.Next()
+	}
+}
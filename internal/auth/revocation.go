@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/jwt"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
+)
+
+// RevocationCache tells whether the session an access token belongs to has been revoked.
+// Access tokens outlive logouts until they expire, so every authenticated request must check that
+// the session still exists. Lookups are cached for TTL to avoid hitting the database on every request.
+type RevocationCache struct {
+	Sessions *models.SessionsModel
+	TTL      time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]revocationEntry // sessionID -> entry
+	lastSweep time.Time
+}
+
+type revocationEntry struct {
+	userID    string
+	revoked   bool
+	expiresAt time.Time
+}
+
+func NewRevocationCache(sessions *models.SessionsModel, ttl time.Duration) *RevocationCache {
+	return &RevocationCache{
+		Sessions: sessions,
+		TTL:      ttl,
+		entries:  make(map[string]revocationEntry),
+	}
+}
+
+// IsRevoked reports whether the session has been deleted or has expired.
+func (r *RevocationCache) IsRevoked(userID, sessionID string) (bool, error) {
+	r.mu.Lock()
+	entry, ok := r.entries[sessionID]
+	r.mu.Unlock()
+
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.revoked, nil
+	}
+
+	active, err := r.Sessions.IsActive(userID, sessionID)
+	if err != nil {
+		return false, err
+	}
+
+	r.store(sessionID, userID, !active)
+
+	return !active, nil
+}
+
+// Revoke marks the session as revoked right away instead of waiting for the cached lookup to expire.
+func (r *RevocationCache) Revoke(sessionID string) {
+	r.store(sessionID, "", true)
+}
+
+// InvalidateUser forgets every cached lookup for the user's sessions, used when all of them are revoked at once.
+func (r *RevocationCache) InvalidateUser(userID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for sessionID, entry := range r.entries {
+		if entry.userID == userID {
+			delete(r.entries, sessionID)
+		}
+	}
+}
+
+func (r *RevocationCache) store(sessionID, userID string, revoked bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+
+	// Drop expired entries every now and then so the cache doesn't grow forever
+	if now.Sub(r.lastSweep) > r.TTL {
+		for id, entry := range r.entries {
+			if now.After(entry.expiresAt) {
+				delete(r.entries, id)
+			}
+		}
+		r.lastSweep = now
+	}
+
+	// A lookup that started before the session was revoked must not overwrite the revocation
+	if current, ok := r.entries[sessionID]; ok && current.revoked && now.Before(current.expiresAt) {
+		return
+	}
+
+	// A revocation can't be undone so it never has to be looked up again, keep it for as long as tokens live
+	ttl := r.TTL
+	if revoked {
+		ttl = max(ttl, jwt.AccessTokenExpirationDelta)
+	}
+
+	r.entries[sessionID] = revocationEntry{
+		userID:    userID,
+		revoked:   revoked,
+		expiresAt: now.Add(ttl),
+	}
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
+)
+
+// ThrottlePolicy describes how many failures are tolerated before attempts are delayed.
+// After FreeFailures failures every new one doubles the delay, starting at BaseDelay, up to MaxDelay.
+type ThrottlePolicy struct {
+	FreeFailures int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	Window       time.Duration // Failures older than this are forgotten
+}
+
+var (
+	// Protects a single account from password guessing
+	AccountThrottlePolicy = ThrottlePolicy{FreeFailures: 5, BaseDelay: 30 * time.Second, MaxDelay: 15 * time.Minute, Window: time.Hour}
+
+	// Protects against a single client trying a few passwords on many accounts
+	IPThrottlePolicy = ThrottlePolicy{FreeFailures: 20, BaseDelay: 30 * time.Second, MaxDelay: 15 * time.Minute, Window: 15 * time.Minute}
+)
+
+// retryAfter returns how long the caller has to wait before the next attempt is allowed.
+func (p ThrottlePolicy) retryAfter(stats models.FailureStats, now time.Time) time.Duration {
+	if stats.Failures < p.FreeFailures {
+		return 0
+	}
+
+	// Doubled one step at a time so a long streak of failures can't overflow the delay
+	delay := min(p.BaseDelay, p.MaxDelay)
+	for range stats.Failures - p.FreeFailures {
+		if delay >= p.MaxDelay/2 {
+			delay = p.MaxDelay
+			break
+		}
+
+		delay *= 2
+	}
+
+	return max(stats.LastFailure.Add(delay).Sub(now), 0)
+}
+
+// LoginThrottle applies exponential backoff to logins per account and per IP address.
+// Both password logins and second factor verifications count as attempts.
+type LoginThrottle struct {
+	Attempts *models.LoginAttemptModel
+}
+
+// Start begins an attempt for the email from the IP address. It returns how long the account and the IP address
+// must wait before trying again, in which case nothing is recorded and the attempt ID is empty.
+// The attempt counts as a failure until Finish says otherwise, so a burst of concurrent guesses is throttled
+// as if they had been made one after the other.
+func (t *LoginThrottle) Start(email, ip string) (attemptID string, account time.Duration, client time.Duration, err error) {
+	now := time.Now()
+
+	attemptID, err = t.Attempts.StartAttempt(strings.ToLower(email), ip,
+		now.Add(-AccountThrottlePolicy.Window),
+		now.Add(-IPThrottlePolicy.Window),
+		func(accountStats, ipStats models.FailureStats) bool {
+			account = AccountThrottlePolicy.retryAfter(accountStats, now)
+			client = IPThrottlePolicy.retryAfter(ipStats, now)
+
+			return account == 0 && client == 0
+		},
+	)
+
+	return attemptID, account, client, err
+}
+
+// Finish stores the outcome of an attempt started with Start.
+func (t *LoginThrottle) Finish(attemptID, userID string, succeeded bool) error {
+	return t.Attempts.FinishAttempt(attemptID, userID, succeeded)
+}
+
+// Prune deletes the attempts too old to count towards any policy.
+func (t *LoginThrottle) Prune() error {
+	window := max(AccountThrottlePolicy.Window, IPThrottlePolicy.Window)
+
+	return t.Attempts.DeleteAttemptsBefore(time.Now().Add(-window))
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
+)
+
+func TestRetryAfter(t *testing.T) {
+	policy := ThrottlePolicy{FreeFailures: 3, BaseDelay: 10 * time.Second, MaxDelay: time.Minute, Window: time.Hour}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		failures    int
+		lastFailure time.Time
+		want        time.Duration
+	}{
+		{"no failures", 0, time.Time{}, 0},
+		{"free failures", 2, now, 0},
+		{"first delayed failure", 3, now, 10 * time.Second},
+		{"doubles after each failure", 4, now, 20 * time.Second},
+		{"doubles again", 5, now, 40 * time.Second},
+		{"capped at max delay", 6, now, time.Minute},
+		{"long streak stays capped", 1000, now, time.Minute},
+		{"time since the last failure counts", 4, now.Add(-15 * time.Second), 5 * time.Second},
+		{"delay already over", 4, now.Add(-time.Minute), 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats := models.FailureStats{Failures: test.failures, LastFailure: test.lastFailure}
+
+			got := policy.retryAfter(stats, now)
+			if got != test.want {
+				t.Fatalf("retryAfter(%d failures) = %v, want %v", test.failures, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRetryAfterBaseDelayOverMax(t *testing.T) {
+	policy := ThrottlePolicy{FreeFailures: 0, BaseDelay: time.Hour, MaxDelay: time.Minute}
+	now := time.Now()
+
+	got := policy.retryAfter(models.FailureStats{Failures: 1, LastFailure: now}, now)
+	if got != time.Minute {
+		t.Fatalf("retryAfter = %v, want %v", got, time.Minute)
+	}
+}
@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/jwt"
+)
+
+var ErrInvalidToken = errors.New("auth: invalid access token")
+var ErrSessionRevoked = errors.New("auth: the session has been revoked")
+
+// Verifier authenticates access tokens for both the HTTP API and the websocket gateway.
+type Verifier struct {
+	Keys        *jwt.KeyManager
+	Revocations *RevocationCache
+}
+
+// VerifyAccessToken checks the token's signature and expiration and that its session is still active.
+// Tokens that fail verification return ErrInvalidToken, which also matches jwt.ErrTokenExpired if they expired,
+// and tokens of ended sessions return ErrSessionRevoked. Any other error means the check itself failed.
+func (v *Verifier) VerifyAccessToken(accessToken string) (jwt.AccessClaims, error) {
+	claims, err := v.Keys.ParseAccessToken(accessToken)
+	if err != nil {
+		return claims, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	revoked, err := v.Revocations.IsRevoked(claims.UserID, claims.SessionID)
+	if err != nil {
+		return claims, err
+	}
+
+	if revoked {
+		return claims, ErrSessionRevoked
+	}
+
+	return claims, nil
+}
@@ -1,6 +1,10 @@
 package internal
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
@@ -37,6 +41,24 @@ func GenerateID() string {
 	return id.String()
 }
 
+// GenerateSecureToken returns a random URL safe token, meant for links sent by email.
+func GenerateSecureToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the SHA-256 hash of the token. Only hashes are stored so a database leak doesn't expose usable tokens.
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(hash[:])
+}
+
 func ServerError(c *fiber.Ctx, err error, errorMsg string) error {
 	log.Error(err)
 	fmt.Println(string(debug.Stack()))
@@ -0,0 +1,308 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrTokenExpired = jwt.ErrTokenExpired
+var ErrUnknownKey = errors.New("jwt: the token was signed with an unknown key")
+var ErrNoSigningKey = errors.New("jwt: no active signing key")
+
+type AccessClaims struct {
+	UserID    string
+	SessionID string
+}
+
+type verificationKey struct {
+	method jwt.SigningMethod
+	public crypto.PublicKey
+}
+
+// KeyManager signs access tokens with the active key and verifies them with any known key.
+//
+// Keys are rotated by adding the new key, making it active once every instance knows it, and
+// keeping the old key as a verification only key until the tokens it signed have expired.
+type KeyManager struct {
+	mu        sync.RWMutex
+	keys      map[string]verificationKey // kid -> key
+	signers   map[string]crypto.Signer   // kid -> private key, only for keys this instance can sign with
+	activeKID string
+}
+
+func NewKeyManager() *KeyManager {
+	return &KeyManager{
+		keys:    make(map[string]verificationKey),
+		signers: make(map[string]crypto.Signer),
+	}
+}
+
+// LoadKeyManager loads every PEM file in dir, using the file name without extension as the key ID.
+// Files holding a PKCS #8 private key can sign tokens, files holding a public key can only verify them.
+// activeKID selects the signing key and can be empty if there's exactly one private key.
+func LoadKeyManager(dir, activeKID string) (*KeyManager, error) {
+	km := NewKeyManager()
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		err = km.addPEM(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: loading key %s: %w", kid, err)
+		}
+	}
+
+	if activeKID == "" {
+		if len(km.signers) != 1 {
+			return nil, fmt.Errorf("jwt: found %d private keys in %s, the active key must be specified", len(km.signers), dir)
+		}
+
+		for kid := range km.signers {
+			activeKID = kid
+		}
+	}
+
+	err = km.SetActive(activeKID)
+	if err != nil {
+		return nil, err
+	}
+
+	return km, nil
+}
+
+func (km *KeyManager) addPEM(kid string, data []byte) error {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return errors.New("no PEM data found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return errors.New("unsupported private key")
+		}
+
+		return km.AddSigningKey(kid, signer)
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+
+		return km.AddVerificationKey(kid, key)
+	default:
+		return fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+}
+
+func signingMethodFor(public crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch key := public.(type) {
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return nil, errors.New("only P-256 ECDSA keys are supported")
+		}
+
+		return jwt.SigningMethodES256, nil
+	default:
+		return nil, errors.New("only Ed25519 and P-256 ECDSA keys are supported")
+	}
+}
+
+// AddVerificationKey adds a key that's only used to verify tokens, such as a key being rotated out.
+func (km *KeyManager) AddVerificationKey(kid string, public crypto.PublicKey) error {
+	method, err := signingMethodFor(public)
+	if err != nil {
+		return err
+	}
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	km.keys[kid] = verificationKey{method: method, public: public}
+
+	return nil
+}
+
+func (km *KeyManager) AddSigningKey(kid string, signer crypto.Signer) error {
+	err := km.AddVerificationKey(kid, signer.Public())
+	if err != nil {
+		return err
+	}
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	km.signers[kid] = signer
+
+	return nil
+}
+
+// GenerateSigningKey creates a new Ed25519 key and makes it the active one. Tokens signed with it
+// can't be verified by other instances or after a restart, so it's only meant for development.
+func (km *KeyManager) GenerateSigningKey() (string, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	kid := "dev-" + time.Now().UTC().Format("20060102150405")
+	err = km.AddSigningKey(kid, private)
+	if err != nil {
+		return "", err
+	}
+
+	return kid, km.SetActive(kid)
+}
+
+func (km *KeyManager) SetActive(kid string) error {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	if _, ok := km.signers[kid]; !ok {
+		return fmt.Errorf("jwt: no private key with ID %q", kid)
+	}
+
+	km.activeKID = kid
+
+	return nil
+}
+
+// GenerateAccessToken signs an access token for the session with the active key.
+func (km *KeyManager) GenerateAccessToken(userID, sessionID string) (string, error) {
+	km.mu.RLock()
+	kid := km.activeKID
+	signer, ok := km.signers[kid]
+	method := km.keys[kid].method
+	km.mu.RUnlock()
+
+	if !ok {
+		return "", ErrNoSigningKey
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(method, jwt.MapClaims{
+		"userID":    userID,
+		"sessionID": sessionID,
+		"iat":       now.Unix(),
+		"exp":       now.Add(AccessTokenExpirationDelta).Unix(), // Token expires after 15 minutes
+	})
+	token.Header["kid"] = kid
+
+	return token.SignedString(signer)
+}
+
+// ParseAccessToken verifies the signature and expiration of an access token and returns its claims.
+func (km *KeyManager) ParseAccessToken(accessToken string) (AccessClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(accessToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		km.mu.RLock()
+		key, ok := km.keys[kid]
+		km.mu.RUnlock()
+
+		// The algorithm is pinned to the key so a token can't pick how it's verified
+		if !ok || token.Method.Alg() != key.method.Alg() {
+			return nil, ErrUnknownKey
+		}
+
+		return key.public, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodES256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return AccessClaims{}, err
+	}
+
+	userID, _ := claims["userID"].(string)
+	sessionID, _ := claims["sessionID"].(string)
+	if userID == "" || sessionID == "" {
+		return AccessClaims{}, jwt.ErrTokenInvalidClaims
+	}
+
+	return AccessClaims{UserID: userID, SessionID: sessionID}, nil
+}
+
+// JWK is the public part of a key as described in RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y,omitempty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns every key tokens may currently be signed with, so other services can verify them.
+func (km *KeyManager) JWKS() JWKSet {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for kid, key := range km.keys {
+		jwk := JWK{Kid: kid, Alg: key.method.Alg(), Use: "sig"}
+
+		switch public := key.public.(type) {
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		case *ecdsa.PublicKey:
+			ecdhKey, err := public.ECDH()
+			if err != nil {
+				continue
+			}
+
+			// Uncompressed point: 0x04 || X || Y
+			point := ecdhKey.Bytes()
+			jwk.Kty = "EC"
+			jwk.Crv = "P-256"
+			jwk.X = base64.RawURLEncoding.EncodeToString(point[1:33])
+			jwk.Y = base64.RawURLEncoding.EncodeToString(point[33:])
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+
+	return set
+}
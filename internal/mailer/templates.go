@@ -0,0 +1,75 @@
+package mailer
+
+import (
+	"fmt"
+	"time"
+)
+
+// How dates are written in emails, always in UTC
+const dateFormat = "January 2, 2006 at 15:04 MST"
+
+func VerificationEmail(link string, expiresAt time.Time) (string, string) {
+	subject := "Verify your Iris email address"
+	body := fmt.Sprintf(`Welcome to Iris!
+
+Please confirm your email address by opening the link below:
+
+%s
+
+The link is valid until %s. If you didn't create an Iris account you can ignore this email.
+`, link, expiresAt.UTC().Format(dateFormat))
+
+	return subject, body
+}
+
+func PasswordResetEmail(link string, expiresAt time.Time) (string, string) {
+	subject := "Reset your Iris password"
+	body := fmt.Sprintf(`We received a request to reset the password of your Iris account.
+
+Open the link below to choose a new password:
+
+%s
+
+The link is valid until %s and can only be used once. If you didn't request a password reset you can ignore this email, your password won't change.
+`, link, expiresAt.UTC().Format(dateFormat))
+
+	return subject, body
+}
+
+func MagicLinkEmail(link string, expiresAt time.Time) (string, string) {
+	subject := "Your Iris login link"
+	body := fmt.Sprintf(`Open the link below to log in to your Iris account:
+
+%s
+
+The link is valid until %s and can only be used once. If you didn't ask to log in you can ignore this email, nobody can access your account without it.
+`, link, expiresAt.UTC().Format(dateFormat))
+
+	return subject, body
+}
+
+func AccountDeletionEmail(scheduledFor time.Time) (string, string) {
+	subject := "Your Iris account will be deleted"
+	body := fmt.Sprintf(`We received a request to delete your Iris account.
+
+Your account and personal data will be permanently deleted on %s. Your messages will stay in their conversations without your name.
+
+If you change your mind, simply log in before then and the deletion will be cancelled.
+`, scheduledFor.UTC().Format(dateFormat))
+
+	return subject, body
+}
+
+func DataExportReadyEmail(link string, expiresAt time.Time) (string, string) {
+	subject := "Your Iris data export is ready"
+	body := fmt.Sprintf(`The copy of your Iris data you requested is ready.
+
+You can download it from your account settings:
+
+%s
+
+The archive is available until %s, after that you'll need to request a new one.
+`, link, expiresAt.UTC().Format(dateFormat))
+
+	return subject, body
+}
@@ -0,0 +1,45 @@
+-- Tables the API started with. They may already exist in databases created before migrations were added.
+
+CREATE TABLE IF NOT EXISTS users (
+	userID text PRIMARY KEY,
+	username text NOT NULL UNIQUE,
+	email text NOT NULL UNIQUE,
+	password text NOT NULL,
+	displayName text,
+	bio text,
+	customStatus text,
+	profilePictureURL text,
+	joinedAt timestamptz NOT NULL DEFAULT NOW(),
+	updatedAt timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	sessionID text PRIMARY KEY,
+	userID text NOT NULL REFERENCES users,
+	refreshToken text NOT NULL,
+	ipAddress text NOT NULL,
+	platform text NOT NULL,
+	os text NOT NULL,
+	expiresAt timestamptz NOT NULL,
+	updatedAt timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS sessions_userID_idx ON sessions (userID);
+
+-- A pending request is stored from the sender to the receiver, friends have a row in each direction
+CREATE TABLE IF NOT EXISTS relationships (
+	userA text NOT NULL REFERENCES users,
+	userB text NOT NULL REFERENCES users,
+	status text NOT NULL,
+	PRIMARY KEY (userA, userB)
+);
+
+CREATE INDEX IF NOT EXISTS relationships_userB_idx ON relationships (userB);
+
+CREATE TABLE IF NOT EXISTS blockedUsers (
+	userFromID text NOT NULL REFERENCES users,
+	blockedUserID text NOT NULL REFERENCES users,
+	PRIMARY KEY (userFromID, blockedUserID)
+);
+
+CREATE INDEX IF NOT EXISTS blockedUsers_blockedUserID_idx ON blockedUsers (blockedUserID);
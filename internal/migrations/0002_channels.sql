@@ -0,0 +1,22 @@
+CREATE TABLE channels (
+	channelID text PRIMARY KEY,
+	channelName text NOT NULL,
+	ownerID text NOT NULL REFERENCES users,
+	channelType text NOT NULL CHECK (channelType IN ('dm', 'group')),
+	description text,
+	createdAt timestamptz NOT NULL DEFAULT NOW(),
+	updatedAt timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX channels_ownerID_idx ON channels (ownerID);
+
+CREATE TABLE channelMembers (
+	channelID text NOT NULL REFERENCES channels,
+	userID text NOT NULL REFERENCES users,
+	joinedAt timestamptz NOT NULL DEFAULT NOW(),
+	isAdmin boolean NOT NULL DEFAULT false,
+	hidden boolean NOT NULL DEFAULT false,
+	PRIMARY KEY (channelID, userID)
+);
+
+CREATE INDEX channelMembers_userID_idx ON channelMembers (userID);
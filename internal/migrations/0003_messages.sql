@@ -0,0 +1,12 @@
+-- Message IDs are ULIDs, so ordering by ID orders messages by creation time
+CREATE TABLE messages (
+	messageID text PRIMARY KEY,
+	channelID text NOT NULL REFERENCES channels,
+	authorID text NOT NULL REFERENCES users,
+	content text NOT NULL,
+	createdAt timestamptz NOT NULL DEFAULT NOW(),
+	editedAt timestamptz
+);
+
+CREATE INDEX messages_channelID_messageID_idx ON messages (channelID, messageID);
+CREATE INDEX messages_authorID_messageID_idx ON messages (authorID, messageID);
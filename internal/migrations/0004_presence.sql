@@ -0,0 +1,2 @@
+-- Last status picked by the user, restored when they connect again
+ALTER TABLE users ADD COLUMN presence text;
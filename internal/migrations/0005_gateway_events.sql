@@ -0,0 +1,28 @@
+-- Events too large for a NOTIFY payload, read back by every instance from the notification's event ID
+CREATE TABLE gatewayEvents (
+	eventID text PRIMARY KEY,
+	payload jsonb NOT NULL,
+	createdAt timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX gatewayEvents_createdAt_idx ON gatewayEvents (createdAt);
+
+-- Status of every gateway connection, on every instance, expiring when the instance stops refreshing them
+CREATE TABLE presenceConnections (
+	connectionID text PRIMARY KEY,
+	userID text NOT NULL REFERENCES users ON DELETE CASCADE,
+	instanceID text NOT NULL,
+	status text NOT NULL,
+	expiresAt timestamptz NOT NULL
+);
+
+CREATE INDEX presenceConnections_userID_idx ON presenceConnections (userID);
+CREATE INDEX presenceConnections_instanceID_idx ON presenceConnections (instanceID);
+CREATE INDEX presenceConnections_expiresAt_idx ON presenceConnections (expiresAt);
+
+-- Aggregate status last published for each connected user
+CREATE TABLE presences (
+	userID text PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+	status text NOT NULL,
+	updatedAt timestamptz NOT NULL DEFAULT NOW()
+);
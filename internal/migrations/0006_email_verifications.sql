@@ -0,0 +1,12 @@
+ALTER TABLE users ADD COLUMN verified boolean NOT NULL DEFAULT false;
+
+CREATE TABLE emailVerifications (
+	tokenID text PRIMARY KEY,
+	userID text NOT NULL REFERENCES users,
+	tokenHash text NOT NULL UNIQUE,
+	expiresAt timestamptz NOT NULL,
+	usedAt timestamptz,
+	createdAt timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX emailVerifications_userID_createdAt_idx ON emailVerifications (userID, createdAt);
@@ -0,0 +1,29 @@
+CREATE TABLE userMFA (
+	userID text PRIMARY KEY REFERENCES users,
+	secret text NOT NULL,
+	enabled boolean NOT NULL DEFAULT false,
+	-- Time step of the last accepted code, so each code can only be used once
+	lastUsedStep bigint NOT NULL DEFAULT 0,
+	createdAt timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE mfaRecoveryCodes (
+	userID text NOT NULL REFERENCES users,
+	codeHash text NOT NULL,
+	usedAt timestamptz,
+	PRIMARY KEY (userID, codeHash)
+);
+
+-- Logins with a valid password waiting for the second factor
+CREATE TABLE mfaChallenges (
+	challengeID text PRIMARY KEY,
+	userID text NOT NULL REFERENCES users,
+	tokenHash text NOT NULL UNIQUE,
+	platform text NOT NULL,
+	os text NOT NULL,
+	attempts integer NOT NULL DEFAULT 0,
+	expiresAt timestamptz NOT NULL
+);
+
+CREATE INDEX mfaChallenges_userID_idx ON mfaChallenges (userID);
+CREATE INDEX mfaChallenges_expiresAt_idx ON mfaChallenges (expiresAt);
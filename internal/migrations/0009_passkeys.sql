@@ -0,0 +1,25 @@
+CREATE TABLE webauthnCredentials (
+	credentialID bytea PRIMARY KEY,
+	userID text NOT NULL REFERENCES users,
+	name text NOT NULL,
+	credential jsonb NOT NULL,
+	signCount bigint NOT NULL,
+	createdAt timestamptz NOT NULL DEFAULT NOW(),
+	lastUsedAt timestamptz
+);
+
+CREATE INDEX webauthnCredentials_userID_idx ON webauthnCredentials (userID);
+
+-- Registration and login challenges waiting for the authenticator's response. Logins have no user yet
+CREATE TABLE webauthnCeremonies (
+	ceremonyID text PRIMARY KEY,
+	kind text NOT NULL,
+	userID text REFERENCES users,
+	platform text NOT NULL,
+	os text NOT NULL,
+	data jsonb NOT NULL,
+	expiresAt timestamptz NOT NULL
+);
+
+CREATE INDEX webauthnCeremonies_userID_idx ON webauthnCeremonies (userID);
+CREATE INDEX webauthnCeremonies_expiresAt_idx ON webauthnCeremonies (expiresAt);
@@ -0,0 +1,10 @@
+-- Refresh tokens used to be stored in plain text, sessions holding one are ended
+DELETE FROM sessions;
+
+ALTER TABLE sessions
+	DROP COLUMN refreshToken,
+	ADD COLUMN refreshTokenHash text NOT NULL,
+	ADD COLUMN refreshCounter bigint NOT NULL DEFAULT 0,
+	-- Hashes of the tokens the current one replaced, oldest first, to recognise replays
+	ADD COLUMN previousTokenHashes text[] NOT NULL DEFAULT '{}',
+	ADD COLUMN rotatedAt timestamptz NOT NULL DEFAULT NOW();
@@ -0,0 +1,14 @@
+-- Emails are stored lowercased. userID is set once the attempt finishes, if the email belongs to an account
+CREATE TABLE loginAttempts (
+	attemptID text PRIMARY KEY,
+	email text NOT NULL,
+	ipAddress text NOT NULL,
+	userID text REFERENCES users,
+	succeeded boolean NOT NULL,
+	createdAt timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX loginAttempts_email_createdAt_idx ON loginAttempts (email, createdAt);
+CREATE INDEX loginAttempts_ipAddress_createdAt_idx ON loginAttempts (ipAddress, createdAt);
+CREATE INDEX loginAttempts_userID_idx ON loginAttempts (userID);
+CREATE INDEX loginAttempts_createdAt_idx ON loginAttempts (createdAt);
@@ -0,0 +1,27 @@
+-- Accounts created by signing in with a provider get a random password, they can't log in with it until they set one
+ALTER TABLE users ADD COLUMN passwordSet boolean NOT NULL DEFAULT true;
+
+CREATE TABLE identities (
+	provider text NOT NULL,
+	subject text NOT NULL,
+	userID text NOT NULL REFERENCES users,
+	email text NOT NULL,
+	createdAt timestamptz NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (provider, subject),
+	UNIQUE (userID, provider)
+);
+
+-- Sign in requests waiting for the provider to redirect back. userID is set when linking an identity
+CREATE TABLE oidcStates (
+	stateHash text PRIMARY KEY,
+	provider text NOT NULL,
+	nonce text NOT NULL,
+	codeVerifier text NOT NULL,
+	userID text REFERENCES users,
+	platform text NOT NULL,
+	os text NOT NULL,
+	expiresAt timestamptz NOT NULL
+);
+
+CREATE INDEX oidcStates_userID_idx ON oidcStates (userID);
+CREATE INDEX oidcStates_expiresAt_idx ON oidcStates (expiresAt);
@@ -0,0 +1,10 @@
+CREATE TABLE magicLinks (
+	tokenID text PRIMARY KEY,
+	userID text NOT NULL REFERENCES users,
+	tokenHash text NOT NULL UNIQUE,
+	expiresAt timestamptz NOT NULL,
+	usedAt timestamptz,
+	createdAt timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX magicLinks_userID_createdAt_idx ON magicLinks (userID, createdAt);
@@ -0,0 +1,10 @@
+CREATE TABLE accountDeletions (
+	userID text PRIMARY KEY REFERENCES users,
+	scheduledFor timestamptz NOT NULL,
+	requestedAt timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX accountDeletions_scheduledFor_idx ON accountDeletions (scheduledFor);
+
+-- Messages of deleted accounts stay in their conversations without an author
+ALTER TABLE messages ALTER COLUMN authorID DROP NOT NULL;
@@ -0,0 +1,27 @@
+CREATE TABLE dataExports (
+	exportID text PRIMARY KEY,
+	userID text NOT NULL REFERENCES users,
+	status text NOT NULL CHECK (status IN ('pending', 'processing', 'ready', 'failed', 'expired')),
+	-- Identifies the worker building the export, so one that lost it can't complete it
+	claimID text,
+	startedAt timestamptz,
+	completedAt timestamptz,
+	expiresAt timestamptz,
+	downloadTokenHash text UNIQUE,
+	linkExpiresAt timestamptz,
+	createdAt timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX dataExports_userID_idx ON dataExports (userID);
+CREATE INDEX dataExports_status_createdAt_idx ON dataExports (status, createdAt);
+
+-- Archives are stored in chunks written by the worker that claimed the export
+CREATE TABLE dataExportChunks (
+	exportID text NOT NULL REFERENCES dataExports,
+	claimID text NOT NULL,
+	chunkIndex integer NOT NULL,
+	data bytea NOT NULL,
+	PRIMARY KEY (exportID, claimID, chunkIndex)
+);
+
+CREATE INDEX dataExportChunks_claimID_idx ON dataExportChunks (claimID);
@@ -0,0 +1,86 @@
+// Package migrations holds the database schema as numbered SQL files, applied in the order of their names.
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// Apply runs every migration the database doesn't have yet, each in a transaction of its own, and records it in
+// schemaMigrations. Instances starting at the same time wait for each other so every migration runs once.
+func Apply(ctx context.Context, db *pgxpool.Pool) error {
+	query := "CREATE TABLE IF NOT EXISTS schemaMigrations (version text PRIMARY KEY, appliedAt timestamptz NOT NULL DEFAULT NOW())"
+	_, err := db.Exec(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		version := strings.TrimSuffix(entry.Name(), ".sql")
+
+		sql, err := fs.ReadFile(files, entry.Name())
+		if err != nil {
+			return err
+		}
+
+		err = apply(ctx, db, version, string(sql))
+		if err != nil {
+			return fmt.Errorf("migrations: applying %s: %w", version, err)
+		}
+	}
+
+	return nil
+}
+
+func apply(ctx context.Context, db *pgxpool.Pool, version, sql string) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := "SELECT pg_advisory_xact_lock(hashtextextended('schemaMigrations', 0))"
+	_, err = tx.Exec(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	var applied bool
+	query = "SELECT EXISTS (SELECT 1 FROM schemaMigrations WHERE version = $1)"
+	err = tx.QueryRow(ctx, query, version).Scan(&applied)
+	if err != nil {
+		return err
+	}
+
+	if applied {
+		return nil
+	}
+
+	// Only the simple protocol accepts several statements at once
+	_, err = tx.Exec(ctx, sql, pgx.QueryExecModeSimpleProtocol)
+	if err != nil {
+		return err
+	}
+
+	query = "INSERT INTO schemaMigrations (version) VALUES ($1)"
+	_, err = tx.Exec(ctx, query, version)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
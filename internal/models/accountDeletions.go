@@ -0,0 +1,212 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Time users have to change their mind after asking to delete their account
+var AccountDeletionGracePeriod time.Duration = time.Hour * 24 * 30
+
+type AccountDeletionModel struct {
+	DB *pgxpool.Pool
+}
+
+// ScheduleDeletion schedules the user's account to be purged once the grace period ends and returns when.
+// Asking again doesn't postpone an existing request.
+func (m *AccountDeletionModel) ScheduleDeletion(userID string) (time.Time, error) {
+	query := `INSERT INTO accountDeletions (userID, scheduledFor) VALUES ($1, $2)
+		ON CONFLICT (userID) DO UPDATE SET userID = EXCLUDED.userID
+		RETURNING scheduledFor`
+
+	var scheduledFor time.Time
+	err := m.DB.QueryRow(context.Background(), query, userID, time.Now().Add(AccountDeletionGracePeriod)).Scan(&scheduledFor)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return scheduledFor, nil
+}
+
+// CancelDeletion cancels the pending deletion of the user's account, it reports whether there was one.
+func (m *AccountDeletionModel) CancelDeletion(userID string) (bool, error) {
+	query := "DELETE FROM accountDeletions WHERE userID = $1"
+
+	result, err := m.DB.Exec(context.Background(), query, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return result.RowsAffected() > 0, nil
+}
+
+// DueDeletions returns up to limit users whose grace period is over.
+func (m *AccountDeletionModel) DueDeletions(limit int) ([]string, error) {
+	query := "SELECT userID FROM accountDeletions WHERE scheduledFor <= NOW() ORDER BY scheduledFor LIMIT $1"
+
+	userIDs := []string{}
+	rows, err := m.DB.Query(context.Background(), query, limit)
+	if err != nil {
+		return userIDs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID string
+		err = rows.Scan(&userID)
+		if err != nil {
+			return userIDs, err
+		}
+
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs, rows.Err()
+}
+
+// PurgeUser deletes the user and everything tied to them. Their messages stay so conversations keep making sense,
+// but lose their author. Channels the user owned are handed over to an admin, or the longest standing member,
+// and deleted when nobody else is left in them.
+// It reports false without changing anything if the deletion was cancelled or isn't due yet.
+func (m *AccountDeletionModel) PurgeUser(userID string) (bool, error) {
+	tx, err := m.DB.Begin(context.Background())
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(context.Background())
+
+	// Locking the request lets other instances skip this user, and makes a login cancelling it wait for the purge to finish
+	query := "SELECT userID FROM accountDeletions WHERE userID = $1 AND scheduledFor <= NOW() FOR UPDATE SKIP LOCKED"
+	err = tx.QueryRow(context.Background(), query, userID).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	err = transferOwnedChannels(tx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	query = "UPDATE messages SET authorID = NULL WHERE authorID = $1"
+	_, err = tx.Exec(context.Background(), query, userID)
+	if err != nil {
+		return false, err
+	}
+
+	err = deleteAllSessions(tx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	queries := []string{
+		"DELETE FROM channelMembers WHERE userID = $1",
+		"DELETE FROM relationships WHERE userA = $1 OR userB = $1",
+		"DELETE FROM blockedUsers WHERE userFromID = $1 OR blockedUserID = $1",
+		"DELETE FROM emailVerifications WHERE userID = $1",
+		"DELETE FROM passwordResets WHERE userID = $1",
+		"DELETE FROM magicLinks WHERE userID = $1",
+		"DELETE FROM mfaChallenges WHERE userID = $1",
+		"DELETE FROM mfaRecoveryCodes WHERE userID = $1",
+		"DELETE FROM userMFA WHERE userID = $1",
+		"DELETE FROM webauthnCeremonies WHERE userID = $1",
+		"DELETE FROM webauthnCredentials WHERE userID = $1",
+		"DELETE FROM oidcStates WHERE userID = $1",
+		"DELETE FROM identities WHERE userID = $1",
+		"DELETE FROM dataExportChunks WHERE exportID IN (SELECT exportID FROM dataExports WHERE userID = $1)",
+		"DELETE FROM dataExports WHERE userID = $1",
+		"DELETE FROM loginAttempts WHERE userID = $1 OR email = (SELECT email FROM users WHERE userID = $1)",
+		"DELETE FROM accountDeletions WHERE userID = $1",
+		"DELETE FROM users WHERE userID = $1",
+	}
+
+	for _, query := range queries {
+		_, err = tx.Exec(context.Background(), query, userID)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = tx.Commit(context.Background())
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// transferOwnedChannels hands the channels owned by the user to another member, deleting the ones left empty.
+func transferOwnedChannels(tx pgx.Tx, userID string) error {
+	query := "SELECT channelID FROM channels WHERE ownerID = $1"
+
+	rows, err := tx.Query(context.Background(), query, userID)
+	if err != nil {
+		return err
+	}
+
+	channelIDs := []string{}
+	for rows.Next() {
+		var channelID string
+		err = rows.Scan(&channelID)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		channelIDs = append(channelIDs, channelID)
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	for _, channelID := range channelIDs {
+		query = `SELECT userID FROM channelMembers WHERE channelID = $1 AND userID != $2
+			ORDER BY isAdmin DESC, joinedAt ASC LIMIT 1`
+
+		var newOwnerID string
+		err = tx.QueryRow(context.Background(), query, channelID, userID).Scan(&newOwnerID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return err
+		}
+
+		if errors.Is(err, pgx.ErrNoRows) {
+			for _, query := range []string{
+				"DELETE FROM messages WHERE channelID = $1",
+				"DELETE FROM channelMembers WHERE channelID = $1",
+				"DELETE FROM channels WHERE channelID = $1",
+			} {
+				_, err = tx.Exec(context.Background(), query, channelID)
+				if err != nil {
+					return err
+				}
+			}
+
+			continue
+		}
+
+		query = "UPDATE channels SET ownerID = $1, updatedAt = NOW() WHERE channelID = $2"
+		_, err = tx.Exec(context.Background(), query, newOwnerID, channelID)
+		if err != nil {
+			return err
+		}
+
+		// The owner has every admin permission
+		query = "UPDATE channelMembers SET isAdmin = true WHERE channelID = $1 AND userID = $2"
+		_, err = tx.Exec(context.Background(), query, channelID, newOwnerID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
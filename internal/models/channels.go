@@ -1,65 +1,454 @@
 package models
 
 import (
+	"context"
+	"errors"
 	"time"
 
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+const (
+	ChannelTypeDM    = "dm"
+	ChannelTypeGroup = "group"
+)
+
+var MaxChannelMembers int = 10
+
 type Channel struct {
-	ChannelID   string
-	ChannelName string
-	OwnerID     string
-	ChannelType string
-	Description NullString
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ChannelID   string     `json:"channelID"`
+	ChannelName string     `json:"channelName"`
+	OwnerID     string     `json:"ownerID"`
+	ChannelType string     `json:"channelType"`
+	Description NullString `json:"description"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
 }
 
 type ChannelMember struct {
-	ChannelID string
-	UserID    string
-	JoinedAt  time.Time
-	IsAdmnin  bool
-	Hidden    bool
+	ChannelID string    `json:"channelID"`
+	UserID    string    `json:"userID"`
+	JoinedAt  time.Time `json:"joinedAt"`
+	IsAdmin   bool      `json:"isAdmin"`
+	Hidden    bool      `json:"hidden"`
+}
+
+type ChannelMemberDTO struct {
+	UserID            string     `json:"userID"`
+	Username          string     `json:"username"`
+	DisplayName       NullString `json:"displayName"`
+	ProfilePictureURL NullString `json:"profilePictureURL"`
+	JoinedAt          time.Time  `json:"joinedAt"`
+	IsAdmin           bool       `json:"isAdmin"`
 }
 
 type ChannelModel struct {
 	DB *pgxpool.Pool
 }
 
-func (m *ChannelModel) CreateChannel() {
+func (m *ChannelModel) CreateChannel(ownerID, channelName, description string, memberIDs []string) (Channel, error) {
+	if len(memberIDs)+1 > MaxChannelMembers {
+		return Channel{}, ErrMaxMembers
+	}
+
+	channel := Channel{
+		ChannelID:   internal.GenerateID(),
+		ChannelName: channelName,
+		OwnerID:     ownerID,
+		ChannelType: ChannelTypeGroup,
+		Description: NullString(description),
+	}
+
+	tx, err := m.DB.Begin(context.Background())
+	if err != nil {
+		return Channel{}, err
+	}
+	defer tx.Rollback(context.Background())
+
+	query := "INSERT INTO channels (channelID, channelName, ownerID, channelType, description) VALUES ($1, $2, $3, $4, NULLIF($5, '')) RETURNING createdAt, updatedAt"
+	err = tx.QueryRow(context.Background(), query, channel.ChannelID, channelName, ownerID, ChannelTypeGroup, description).Scan(&channel.CreatedAt, &channel.UpdatedAt)
+	if err != nil {
+		return Channel{}, err
+	}
+
+	// The owner is always the first member and an admin of the channel
+	query = "INSERT INTO channelMembers (channelID, userID, isAdmin) VALUES ($1, $2, true)"
+	_, err = tx.Exec(context.Background(), query, channel.ChannelID, ownerID)
+	if err != nil {
+		return Channel{}, err
+	}
+
+	for _, memberID := range memberIDs {
+		if memberID == ownerID {
+			continue
+		}
+
+		err = addFriendAsMember(tx, channel.ChannelID, ownerID, memberID)
+		if err != nil {
+			return Channel{}, err
+		}
+	}
+
+	err = tx.Commit(context.Background())
+	if err != nil {
+		return Channel{}, err
+	}
+
+	return channel, nil
+}
+
+func (m *ChannelModel) DeleteChannel(channelID string) error {
+	tx, err := m.DB.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	query := "DELETE FROM messages WHERE channelID = $1"
+	_, err = tx.Exec(context.Background(), query, channelID)
+	if err != nil {
+		return err
+	}
+
+	query = "DELETE FROM channelMembers WHERE channelID = $1"
+	_, err = tx.Exec(context.Background(), query, channelID)
+	if err != nil {
+		return err
+	}
+
+	query = "DELETE FROM channels WHERE channelID = $1"
+	res, err := tx.Exec(context.Background(), query, channelID)
+	if err != nil {
+		return err
+	}
+
+	if res.RowsAffected() < 1 {
+		return ErrChannelNotFound
+	}
+
+	return tx.Commit(context.Background())
+}
+
+func (m *ChannelModel) AddMember(channelID, inviterID, userID string) (ChannelMember, error) {
+	tx, err := m.DB.Begin(context.Background())
+	if err != nil {
+		return ChannelMember{}, err
+	}
+	defer tx.Rollback(context.Background())
+
+	// Lock the channel row so concurrent invites can't exceed the member limit
+	query := "SELECT channelID FROM channels WHERE channelID = $1 FOR UPDATE"
+	err = tx.QueryRow(context.Background(), query, channelID).Scan(&channelID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ChannelMember{}, ErrChannelNotFound
+		}
+
+		return ChannelMember{}, err
+	}
+
+	var count int
+	query = "SELECT COUNT(*) FROM channelMembers WHERE channelID = $1"
+	err = tx.QueryRow(context.Background(), query, channelID).Scan(&count)
+	if err != nil {
+		return ChannelMember{}, err
+	}
+
+	if count >= MaxChannelMembers {
+		return ChannelMember{}, ErrMaxMembers
+	}
+
+	err = addFriendAsMember(tx, channelID, inviterID, userID)
+	if err != nil {
+		return ChannelMember{}, err
+	}
+
+	member, err := fetchMember(tx, channelID, userID)
+	if err != nil {
+		return ChannelMember{}, err
+	}
+
+	err = tx.Commit(context.Background())
+	if err != nil {
+		return ChannelMember{}, err
+	}
+
+	return member, nil
+}
+
+func (m *ChannelModel) RemoveMember(channelID, userID string) error {
+	query := "DELETE FROM channelMembers WHERE channelID = $1 AND userID = $2"
+
+	res, err := m.DB.Exec(context.Background(), query, channelID, userID)
+	if err != nil {
+		return err
+	}
+
+	if res.RowsAffected() < 1 {
+		return ErrNotChannelMember
+	}
+
+	return nil
+}
+
+func (m *ChannelModel) FetchChannel(channelID string) (Channel, error) {
+	query := "SELECT channelID, channelName, ownerID, channelType, description, createdAt, updatedAt FROM channels WHERE channelID = $1"
 
+	channel := Channel{}
+	row := m.DB.QueryRow(context.Background(), query, channelID)
+	err := row.Scan(&channel.ChannelID, &channel.ChannelName, &channel.OwnerID, &channel.ChannelType, &channel.Description, &channel.CreatedAt, &channel.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return channel, ErrChannelNotFound
+		}
+
+		return channel, err
+	}
+
+	return channel, nil
 }
 
-func (m *ChannelModel) DeleteChannel() {
+// FetchChannels returns every channel the user is a member of, excluding the ones they have hidden.
+func (m *ChannelModel) FetchChannels(userID string) ([]Channel, error) {
+	query := `SELECT c.channelID, c.channelName, c.ownerID, c.channelType, c.description, c.createdAt, c.updatedAt
+				FROM channels c
+					JOIN channelMembers cm ON cm.channelID = c.channelID
+					WHERE cm.userID = $1 AND cm.hidden = false
+					ORDER BY c.updatedAt DESC;`
+
+	rows, err := m.DB.Query(context.Background(), query, userID)
+	if err != nil {
+		return []Channel{}, err
+	}
+	defer rows.Close()
+
+	channels := []Channel{}
+	for rows.Next() {
+		var channel Channel
+		err := rows.Scan(&channel.ChannelID, &channel.ChannelName, &channel.OwnerID, &channel.ChannelType, &channel.Description, &channel.CreatedAt, &channel.UpdatedAt)
+		if err != nil {
+			return []Channel{}, err
+		}
+
+		channels = append(channels, channel)
+	}
 
+	return channels, rows.Err()
 }
 
-func (m *ChannelModel) AddMember() {
+func (m *ChannelModel) FetchMembers(channelID string) ([]ChannelMemberDTO, error) {
+	query := `SELECT u.userID, u.username, u.displayName, u.profilePictureURL, cm.joinedAt, cm.isAdmin
+				FROM channelMembers cm
+					JOIN users u ON u.userID = cm.userID
+					WHERE cm.channelID = $1
+					ORDER BY cm.joinedAt ASC;`
 
+	rows, err := m.DB.Query(context.Background(), query, channelID)
+	if err != nil {
+		return []ChannelMemberDTO{}, err
+	}
+	defer rows.Close()
+
+	members := []ChannelMemberDTO{}
+	for rows.Next() {
+		var member ChannelMemberDTO
+		err := rows.Scan(&member.UserID, &member.Username, &member.DisplayName, &member.ProfilePictureURL, &member.JoinedAt, &member.IsAdmin)
+		if err != nil {
+			return []ChannelMemberDTO{}, err
+		}
+
+		members = append(members, member)
+	}
+
+	return members, rows.Err()
 }
 
-func (m *ChannelModel) RemoveMember() {
+// FetchMemberIDs returns the user IDs of every member of the channel.
+func (m *ChannelModel) FetchMemberIDs(channelID string) ([]string, error) {
+	query := "SELECT userID FROM channelMembers WHERE channelID = $1"
+
+	rows, err := m.DB.Query(context.Background(), query, channelID)
+	if err != nil {
+		return []string{}, err
+	}
+	defer rows.Close()
+
+	userIDs := []string{}
+	for rows.Next() {
+		var userID string
+		err := rows.Scan(&userID)
+		if err != nil {
+			return []string{}, err
+		}
 
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs, rows.Err()
+}
+
+func (m *ChannelModel) FetchMember(channelID, userID string) (ChannelMember, error) {
+	return fetchMember(m.DB, channelID, userID)
 }
 
-func (m *ChannelModel) FetchChannel() {
+func (m *ChannelModel) ChangeAdminPerms(channelID, userID string, isAdmin bool) error {
+	query := "UPDATE channelMembers SET isAdmin = $1 WHERE channelID = $2 AND userID = $3"
+
+	res, err := m.DB.Exec(context.Background(), query, isAdmin, channelID, userID)
+	if err != nil {
+		return err
+	}
+
+	if res.RowsAffected() < 1 {
+		return ErrNotChannelMember
+	}
 
+	return nil
 }
 
-func (m *ChannelModel) FetchChannels() {
+func (m *ChannelModel) ChangeChannelHiddenState(channelID, userID string, hidden bool) error {
+	query := "UPDATE channelMembers SET hidden = $1 WHERE channelID = $2 AND userID = $3"
 
+	res, err := m.DB.Exec(context.Background(), query, hidden, channelID, userID)
+	if err != nil {
+		return err
+	}
+
+	if res.RowsAffected() < 1 {
+		return ErrNotChannelMember
+	}
+
+	return nil
 }
 
-func (m *ChannelModel) FetchMembers() {
+// IsBlocked reports whether the channel is a direct message where either participant has blocked the other.
+func (m *ChannelModel) IsBlocked(channelID, userID string) (bool, error) {
+	query := `SELECT EXISTS (
+				SELECT 1 FROM channels c
+					JOIN channelMembers cm ON cm.channelID = c.channelID AND cm.userID <> $2
+					JOIN blockedUsers b ON (b.userFromID = $2 AND b.blockedUserID = cm.userID)
+						OR (b.userFromID = cm.userID AND b.blockedUserID = $2)
+					WHERE c.channelID = $1 AND c.channelType = $3
+			);`
 
+	var blocked bool
+	err := m.DB.QueryRow(context.Background(), query, channelID, userID, ChannelTypeDM).Scan(&blocked)
+	if err != nil {
+		return false, err
+	}
+
+	return blocked, nil
 }
 
-func (m *ChannelModel) ChangeAdminPerms() {
+// queryer is satisfied by both *pgxpool.Pool and pgx.Tx so helpers can run inside or outside a transaction.
+type queryer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
 
+func fetchMember(q queryer, channelID, userID string) (ChannelMember, error) {
+	query := "SELECT channelID, userID, joinedAt, isAdmin, hidden FROM channelMembers WHERE channelID = $1 AND userID = $2"
+
+	member := ChannelMember{}
+	err := q.QueryRow(context.Background(), query, channelID, userID).Scan(&member.ChannelID, &member.UserID, &member.JoinedAt, &member.IsAdmin, &member.Hidden)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return member, ErrNotChannelMember
+		}
+
+		return member, err
+	}
+
+	return member, nil
+}
+
+// addFriendAsMember inserts userID into the channel only if the inviter and the user are friends.
+func addFriendAsMember(q queryer, channelID, inviterID, userID string) error {
+	query := `INSERT INTO channelMembers (channelID, userID)
+				SELECT $1, $2 WHERE EXISTS (
+					SELECT 1 FROM relationships WHERE userA = $3 AND userB = $2 AND status = 'accepted'
+				)`
+
+	res, err := q.Exec(context.Background(), query, channelID, userID, inviterID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			if pgErr.Code == "23505" { // Unique constraint violation because the user is already a member
+				return ErrMemberExists
+			}
+
+			if pgErr.Code == "23503" { // Foreign key constraint violation due to user or channel not found
+				return ErrUserNotFound
+			}
+		}
+
+		return err
+	}
+
+	if res.RowsAffected() < 1 {
+		return ErrNotFriends
+	}
+
+	return nil
 }
 
-func (m *ChannelModel) ChangeChannelHiddenState() {
+// createDMChannel returns the direct message channel between userA and userB, creating it if one doesn't exist yet.
+// It must run inside a transaction so that the lookup and the insert happen atomically.
+func createDMChannel(tx pgx.Tx, userA, userB string) (Channel, error) {
+	// Serialize DM creation for this pair of users so concurrent calls can't create duplicate channels
+	pairKey := userA + ":" + userB
+	if userB < userA {
+		pairKey = userB + ":" + userA
+	}
+
+	_, err := tx.Exec(context.Background(), "SELECT pg_advisory_xact_lock(hashtext($1))", pairKey)
+	if err != nil {
+		return Channel{}, err
+	}
+
+	query := `SELECT c.channelID, c.channelName, c.ownerID, c.channelType, c.description, c.createdAt, c.updatedAt
+				FROM channels c
+					JOIN channelMembers a ON a.channelID = c.channelID AND a.userID = $1
+					JOIN channelMembers b ON b.channelID = c.channelID AND b.userID = $2
+					WHERE c.channelType = $3;`
+
+	channel := Channel{}
+	err = tx.QueryRow(context.Background(), query, userA, userB, ChannelTypeDM).Scan(&channel.ChannelID, &channel.ChannelName, &channel.OwnerID, &channel.ChannelType, &channel.Description, &channel.CreatedAt, &channel.UpdatedAt)
+	if err == nil {
+		// The channel already exists, make sure it shows up again for both users
+		query = "UPDATE channelMembers SET hidden = false WHERE channelID = $1"
+		_, err = tx.Exec(context.Background(), query, channel.ChannelID)
+		if err != nil {
+			return Channel{}, err
+		}
+
+		return channel, nil
+	}
+
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return Channel{}, err
+	}
+
+	channel = Channel{
+		ChannelID:   internal.GenerateID(),
+		OwnerID:     userA,
+		ChannelType: ChannelTypeDM,
+	}
+
+	query = "INSERT INTO channels (channelID, channelName, ownerID, channelType) VALUES ($1, $2, $3, $4) RETURNING createdAt, updatedAt"
+	err = tx.QueryRow(context.Background(), query, channel.ChannelID, channel.ChannelName, userA, ChannelTypeDM).Scan(&channel.CreatedAt, &channel.UpdatedAt)
+	if err != nil {
+		return Channel{}, err
+	}
+
+	query = "INSERT INTO channelMembers (channelID, userID) VALUES ($1, $2), ($1, $3)"
+	_, err = tx.Exec(context.Background(), query, channel.ChannelID, userA, userB)
+	if err != nil {
+		return Channel{}, err
+	}
 
+	return channel, nil
 }
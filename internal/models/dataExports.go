@@ -0,0 +1,367 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	ExportPending    = "pending"
+	ExportProcessing = "processing"
+	ExportReady      = "ready"
+	ExportFailed     = "failed"
+	ExportExpired    = "expired"
+)
+
+// How long finished archives are kept before they have to be requested again
+var DataExportRetention time.Duration = time.Hour * 24 * 7
+
+// How long a download link stays valid
+var DataExportLinkExpirationDelta time.Duration = time.Minute * 15
+
+// Archives are stored and downloaded in chunks of this size, so neither holds a whole archive in memory
+const dataExportChunkSize = 1 << 20
+
+// Exports still processing after this long are assumed to belong to an instance that stopped and are picked up again
+var DataExportStaleAfter time.Duration = time.Minute * 10
+
+type DataExport struct {
+	ExportID    string     `json:"exportID"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	CompletedAt *time.Time `json:"completedAt"`
+	ExpiresAt   *time.Time `json:"expiresAt"` // When the archive is deleted, set once it's ready
+}
+
+// ChannelMembershipDTO is a channel the user belongs to, as included in their data export.
+type ChannelMembershipDTO struct {
+	ChannelID   string    `json:"channelID"`
+	ChannelName string    `json:"channelName"`
+	ChannelType string    `json:"channelType"`
+	IsOwner     bool      `json:"isOwner"`
+	IsAdmin     bool      `json:"isAdmin"`
+	Hidden      bool      `json:"hidden"`
+	JoinedAt    time.Time `json:"joinedAt"`
+}
+
+type DataExportModel struct {
+	DB *pgxpool.Pool
+}
+
+// CreateExport queues a new export of the user's data, or returns ErrExportInProgress if one is already queued.
+func (m *DataExportModel) CreateExport(userID string) (DataExport, error) {
+	query := `INSERT INTO dataExports (exportID, userID, status)
+		SELECT $1, $2, $3
+		WHERE NOT EXISTS (SELECT 1 FROM dataExports WHERE userID = $2 AND status IN ($3, $4))
+		RETURNING createdAt`
+
+	export := DataExport{ExportID: internal.GenerateID(), Status: ExportPending}
+
+	err := m.DB.QueryRow(context.Background(), query, export.ExportID, userID, ExportPending, ExportProcessing).Scan(&export.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return export, ErrExportInProgress
+		}
+
+		return export, err
+	}
+
+	return export, nil
+}
+
+func (m *DataExportModel) FetchExport(userID, exportID string) (DataExport, error) {
+	query := "SELECT exportID, status, createdAt, completedAt, expiresAt FROM dataExports WHERE userID = $1 AND exportID = $2"
+
+	var export DataExport
+	err := m.DB.QueryRow(context.Background(), query, userID, exportID).Scan(&export.ExportID, &export.Status, &export.CreatedAt, &export.CompletedAt, &export.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return export, ErrExportNotFound
+		}
+
+		return export, err
+	}
+
+	return export, nil
+}
+
+// ExportClaim is a queued export a worker took over. The claim ID changes every time the export is claimed,
+// so a worker that took too long can't overwrite the work of the one that picked the export up after it.
+type ExportClaim struct {
+	ExportID string
+	UserID   string
+	ClaimID  string
+}
+
+// ClaimExport marks the oldest queued export as processing and returns it.
+// ok is false when there's nothing to process.
+func (m *DataExportModel) ClaimExport() (claim ExportClaim, ok bool, err error) {
+	query := `UPDATE dataExports SET status = $1, startedAt = NOW(), claimID = $4
+		WHERE exportID = (
+			SELECT exportID FROM dataExports
+				WHERE status = $2 OR (status = $1 AND startedAt < $3)
+				ORDER BY createdAt
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+		)
+		RETURNING exportID, userID, claimID`
+
+	err = m.DB.QueryRow(context.Background(), query, ExportProcessing, ExportPending, time.Now().Add(-DataExportStaleAfter), internal.GenerateID()).Scan(&claim.ExportID, &claim.UserID, &claim.ClaimID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return claim, false, nil
+		}
+
+		return claim, false, err
+	}
+
+	return claim, true, nil
+}
+
+// RefreshClaim tells other workers the export is still being built so they don't claim it as stale.
+// It returns ErrExportClaimLost if another worker claimed it in the meantime.
+func (m *DataExportModel) RefreshClaim(claim ExportClaim) error {
+	query := "UPDATE dataExports SET startedAt = NOW() WHERE exportID = $1 AND claimID = $2 AND status = $3"
+
+	result, err := m.DB.Exec(context.Background(), query, claim.ExportID, claim.ClaimID, ExportProcessing)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrExportClaimLost
+	}
+
+	return nil
+}
+
+// NewArchiveWriter returns a writer that stores the claimed export's archive in chunks of dataExportChunkSize.
+// Close must be called to store the last chunk.
+func (m *DataExportModel) NewArchiveWriter(claim ExportClaim) *ArchiveWriter {
+	return &ArchiveWriter{model: m, claim: claim}
+}
+
+// CompleteExport marks the export as ready once its archive has been written and returns when it expires,
+// it's kept for DataExportRetention. Chunks left behind by earlier claims are deleted.
+func (m *DataExportModel) CompleteExport(claim ExportClaim) (time.Time, error) {
+	tx, err := m.DB.Begin(context.Background())
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback(context.Background())
+
+	query := "UPDATE dataExports SET status = $1, completedAt = NOW(), expiresAt = $2 WHERE exportID = $3 AND claimID = $4 AND status = $5"
+
+	expiresAt := time.Now().Add(DataExportRetention)
+	result, err := tx.Exec(context.Background(), query, ExportReady, expiresAt, claim.ExportID, claim.ClaimID, ExportProcessing)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if result.RowsAffected() == 0 {
+		return time.Time{}, ErrExportClaimLost
+	}
+
+	query = "DELETE FROM dataExportChunks WHERE exportID = $1 AND claimID <> $2"
+	_, err = tx.Exec(context.Background(), query, claim.ExportID, claim.ClaimID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	err = tx.Commit(context.Background())
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return expiresAt, nil
+}
+
+// FailExport marks the export as failed and deletes the chunks written for it. It does nothing if the export
+// was claimed by another worker, which is still building it.
+func (m *DataExportModel) FailExport(claim ExportClaim) error {
+	query := "UPDATE dataExports SET status = $1, completedAt = NOW() WHERE exportID = $2 AND claimID = $3 AND status = $4"
+
+	_, err := m.DB.Exec(context.Background(), query, ExportFailed, claim.ExportID, claim.ClaimID, ExportProcessing)
+	if err != nil {
+		return err
+	}
+
+	query = "DELETE FROM dataExportChunks WHERE claimID = $1"
+	_, err = m.DB.Exec(context.Background(), query, claim.ClaimID)
+	return err
+}
+
+// CreateDownloadLink stores the hash of a new download token for a ready export, replacing the previous one.
+// It returns when the token expires, or ErrExportNotFound if the export isn't ready or has expired.
+func (m *DataExportModel) CreateDownloadLink(userID, exportID, tokenHash string) (time.Time, error) {
+	query := `UPDATE dataExports SET downloadTokenHash = $1, linkExpiresAt = LEAST($2, expiresAt)
+		WHERE userID = $3 AND exportID = $4 AND status = $5 AND expiresAt > NOW()
+		RETURNING linkExpiresAt`
+
+	var linkExpiresAt time.Time
+	err := m.DB.QueryRow(context.Background(), query, tokenHash, time.Now().Add(DataExportLinkExpirationDelta), userID, exportID, ExportReady).Scan(&linkExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, ErrExportNotFound
+		}
+
+		return time.Time{}, err
+	}
+
+	return linkExpiresAt, nil
+}
+
+// FetchArchive returns a reader of the archive the download token was issued for and when it was requested,
+// or ErrInvalidToken if the token is unknown or expired. Chunks are fetched one at a time as the archive is read.
+func (m *DataExportModel) FetchArchive(tokenHash string) (*ArchiveReader, time.Time, error) {
+	query := "SELECT exportID, claimID, createdAt FROM dataExports WHERE downloadTokenHash = $1 AND linkExpiresAt > NOW() AND status = $2"
+
+	reader := &ArchiveReader{model: m}
+	var createdAt time.Time
+	err := m.DB.QueryRow(context.Background(), query, tokenHash, ExportReady).Scan(&reader.exportID, &reader.claimID, &createdAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, time.Time{}, ErrInvalidToken
+		}
+
+		return nil, time.Time{}, err
+	}
+
+	return reader, createdAt, nil
+}
+
+// DeleteExpiredArchives frees the space of archives past their retention, keeping the export's status.
+// Chunks of failed exports and of exports that were never completed are deleted too.
+func (m *DataExportModel) DeleteExpiredArchives() error {
+	query := "UPDATE dataExports SET status = $1, downloadTokenHash = NULL WHERE status = $2 AND expiresAt <= NOW()"
+
+	_, err := m.DB.Exec(context.Background(), query, ExportExpired, ExportReady)
+	if err != nil {
+		return err
+	}
+
+	query = "DELETE FROM dataExportChunks WHERE exportID IN (SELECT exportID FROM dataExports WHERE status IN ($1, $2))"
+	_, err = m.DB.Exec(context.Background(), query, ExportExpired, ExportFailed)
+	return err
+}
+
+// FetchMemberships returns every channel the user belongs to, including hidden ones.
+func (m *DataExportModel) FetchMemberships(userID string) ([]ChannelMembershipDTO, error) {
+	query := `SELECT c.channelID, c.channelName, c.channelType, c.ownerID = $1, cm.isAdmin, cm.hidden, cm.joinedAt
+				FROM channelMembers cm
+					JOIN channels c ON c.channelID = cm.channelID
+					WHERE cm.userID = $1
+					ORDER BY cm.joinedAt`
+
+	memberships := []ChannelMembershipDTO{}
+	rows, err := m.DB.Query(context.Background(), query, userID)
+	if err != nil {
+		return memberships, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var membership ChannelMembershipDTO
+		err = rows.Scan(&membership.ChannelID, &membership.ChannelName, &membership.ChannelType, &membership.IsOwner, &membership.IsAdmin, &membership.Hidden, &membership.JoinedAt)
+		if err != nil {
+			return memberships, err
+		}
+
+		memberships = append(memberships, membership)
+	}
+
+	return memberships, rows.Err()
+}
+
+// ArchiveWriter stores an archive as it's written, one chunk at a time.
+type ArchiveWriter struct {
+	model  *DataExportModel
+	claim  ExportClaim
+	buffer []byte
+	index  int
+}
+
+func (w *ArchiveWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(dataExportChunkSize-len(w.buffer), len(p))
+		w.buffer = append(w.buffer, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(w.buffer) == dataExportChunkSize {
+			err := w.flush()
+			if err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// Close stores what's left of the archive.
+func (w *ArchiveWriter) Close() error {
+	if len(w.buffer) == 0 {
+		return nil
+	}
+
+	return w.flush()
+}
+
+func (w *ArchiveWriter) flush() error {
+	query := "INSERT INTO dataExportChunks (exportID, claimID, chunkIndex, data) VALUES ($1, $2, $3, $4)"
+
+	_, err := w.model.DB.Exec(context.Background(), query, w.claim.ExportID, w.claim.ClaimID, w.index, w.buffer)
+	if err != nil {
+		return err
+	}
+
+	w.index++
+	w.buffer = w.buffer[:0]
+
+	return nil
+}
+
+// ArchiveReader reads a stored archive, fetching the next chunk once the current one has been read.
+type ArchiveReader struct {
+	model    *DataExportModel
+	exportID string
+	claimID  string
+	chunk    []byte
+	index    int
+	done     bool
+}
+
+func (r *ArchiveReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		query := "SELECT data FROM dataExportChunks WHERE exportID = $1 AND claimID = $2 AND chunkIndex = $3"
+
+		err := r.model.DB.QueryRow(context.Background(), query, r.exportID, r.claimID, r.index).Scan(&r.chunk)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				r.done = true
+				continue
+			}
+
+			return 0, err
+		}
+
+		r.index++
+	}
+
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+
+	return n, nil
+}
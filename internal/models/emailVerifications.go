@@ -0,0 +1,52 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type EmailVerificationModel struct {
+	DB *pgxpool.Pool
+}
+
+var EmailVerificationExpirationDelta time.Duration = time.Hour * 24
+
+func (m *EmailVerificationModel) tokens() oneTimeTokens {
+	return oneTimeTokens{db: m.DB, table: "emailVerifications", expirationDelta: EmailVerificationExpirationDelta}
+}
+
+// CreateToken stores the hash of a new verification token for the user and invalidates any previous unused one.
+// It returns when the token expires.
+func (m *EmailVerificationModel) CreateToken(userID, tokenHash string) (time.Time, error) {
+	return m.tokens().create(userID, tokenHash)
+}
+
+// LastIssuedAt returns when the latest verification token was created for the user.
+func (m *EmailVerificationModel) LastIssuedAt(userID string) (time.Time, error) {
+	return m.tokens().lastIssuedAt(userID)
+}
+
+// ConsumeToken marks the token as used and the user it was issued for as verified.
+// Unknown, expired or already used tokens return ErrInvalidToken.
+func (m *EmailVerificationModel) ConsumeToken(tokenHash string) error {
+	tx, err := m.DB.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	userID, err := m.tokens().consume(tx, tokenHash)
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE users SET verified = true, updatedAt = NOW() WHERE userID = $1"
+	_, err = tx.Exec(context.Background(), query, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
@@ -14,3 +14,21 @@ var ErrUserNotFound = errors.New("models: user not found")
 var ErrMaxFriends = errors.New("models: the user has reached the maximum number of friends")
 var ErrRecipientHasBlockedUser = errors.New("models: the recipient has blocked the client user")
 var ErrNothingToUpdate = errors.New("models: nothing to update")
+var ErrChannelNotFound = errors.New("models: channel not found")
+var ErrNotChannelMember = errors.New("models: the user is not a member of the channel")
+var ErrMemberExists = errors.New("models: the user is already a member of the channel")
+var ErrMaxMembers = errors.New("models: the channel has reached the maximum number of members")
+var ErrNotFriends = errors.New("models: the users are not friends")
+var ErrMessageNotFound = errors.New("models: message not found")
+var ErrInvalidToken = errors.New("models: the token is invalid, expired or has already been used")
+var ErrMFAAlreadyEnabled = errors.New("models: two-factor authentication is already enabled")
+var ErrMFANotEnrolled = errors.New("models: two-factor authentication has not been set up")
+var ErrPasskeyExists = errors.New("models: the passkey is already registered")
+var ErrPasskeyNotFound = errors.New("models: passkey not found")
+var ErrRefreshTokenReused = errors.New("models: a refresh token was used after being rotated")
+var ErrIdentityNotFound = errors.New("models: no identity of the provider is linked")
+var ErrIdentityLinked = errors.New("models: the identity is already linked to another account")
+var ErrLastLoginMethod = errors.New("models: the identity is the only way the user can log in")
+var ErrExportInProgress = errors.New("models: a data export is already in progress")
+var ErrExportNotFound = errors.New("models: data export not found")
+var ErrExportClaimLost = errors.New("models: the data export was claimed again by another worker")
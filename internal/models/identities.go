@@ -0,0 +1,225 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/password"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Time a user has to sign in with the provider and come back
+var OIDCStateExpirationDelta time.Duration = time.Minute * 10
+
+// Identity is an account of an external OpenID Connect provider linked to a user.
+type Identity struct {
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// OIDCState is an authorization request waiting for the provider to redirect the user back.
+type OIDCState struct {
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	UserID       string // Set when an authenticated user is linking an identity, empty for logins
+	Platform     string
+	OS           string
+}
+
+type IdentityModel struct {
+	DB *pgxpool.Pool
+}
+
+// CreateState stores an authorization request under the hash of its state parameter.
+func (m *IdentityModel) CreateState(stateHash string, state OIDCState) error {
+	query := "INSERT INTO oidcStates (stateHash, provider, nonce, codeVerifier, userID, platform, os, expiresAt) VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8)"
+
+	_, err := m.DB.Exec(context.Background(), query, stateHash, state.Provider, state.Nonce, state.CodeVerifier, state.UserID, state.Platform, state.OS, time.Now().Add(OIDCStateExpirationDelta))
+	return err
+}
+
+// ConsumeState removes the authorization request and returns it, so each state can only be used once.
+// Unknown or expired states, or states issued for another provider, return ErrInvalidToken.
+func (m *IdentityModel) ConsumeState(stateHash, provider string) (OIDCState, error) {
+	query := "DELETE FROM oidcStates WHERE stateHash = $1 AND provider = $2 RETURNING nonce, codeVerifier, userID, platform, os, expiresAt"
+
+	state := OIDCState{Provider: provider}
+
+	var userID NullString
+	var expiresAt time.Time
+	err := m.DB.QueryRow(context.Background(), query, stateHash, provider).Scan(&state.Nonce, &state.CodeVerifier, &userID, &state.Platform, &state.OS, &expiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return state, ErrInvalidToken
+		}
+
+		return state, err
+	}
+
+	if expiresAt.Before(time.Now()) {
+		return state, ErrInvalidToken
+	}
+
+	state.UserID = string(userID)
+
+	// Clean up requests users never came back from
+	query = "DELETE FROM oidcStates WHERE expiresAt <= NOW()"
+	_, err = m.DB.Exec(context.Background(), query)
+	if err != nil {
+		return state, err
+	}
+
+	return state, nil
+}
+
+// FetchUserID returns the user the provider's subject is linked to, or ErrIdentityNotFound.
+func (m *IdentityModel) FetchUserID(provider, subject string) (string, error) {
+	query := "SELECT userID FROM identities WHERE provider = $1 AND subject = $2"
+
+	var userID string
+	err := m.DB.QueryRow(context.Background(), query, provider, subject).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrIdentityNotFound
+		}
+
+		return "", err
+	}
+
+	return userID, nil
+}
+
+// LinkIdentity links the provider's subject to the user. Linking it again to the same user does nothing,
+// ErrIdentityLinked is returned if it belongs to someone else or the user already has an identity of that provider.
+func (m *IdentityModel) LinkIdentity(userID, provider, subject, email string) error {
+	query := "INSERT INTO identities (provider, subject, userID, email) VALUES ($1, $2, $3, $4)"
+
+	_, err := m.DB.Exec(context.Background(), query, provider, subject, userID, email)
+	if err != nil {
+		var pgError *pgconn.PgError
+		if errors.As(err, &pgError) && pgError.Code == "23505" {
+			linkedUserID, fetchErr := m.FetchUserID(provider, subject)
+			if fetchErr == nil && linkedUserID == userID {
+				return nil
+			}
+
+			return ErrIdentityLinked
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// CreateUser creates an account for someone signing in with the provider for the first time and links the
+// provider's subject to it, both or neither. The email is marked verified if the provider verified it.
+// The password is random, so the identity stays the only way to log in until the user sets one.
+func (m *IdentityModel) CreateUser(username, email, plainPassword, provider, subject string, emailVerified bool) (string, error) {
+	hashedPassword, err := password.Hash(plainPassword)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := m.DB.Begin(context.Background())
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(context.Background())
+
+	userID, err := insertUser(tx, username, email, hashedPassword, false)
+	if err != nil {
+		return "", err
+	}
+
+	query := "INSERT INTO identities (provider, subject, userID, email) VALUES ($1, $2, $3, $4)"
+	_, err = tx.Exec(context.Background(), query, provider, subject, userID, email)
+	if err != nil {
+		var pgError *pgconn.PgError
+		if errors.As(err, &pgError) && pgError.Code == "23505" {
+			return "", ErrIdentityLinked
+		}
+
+		return "", err
+	}
+
+	if emailVerified {
+		query = "UPDATE users SET verified = TRUE WHERE userID = $1"
+		_, err = tx.Exec(context.Background(), query, userID)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return userID, tx.Commit(context.Background())
+}
+
+func (m *IdentityModel) FetchIdentities(userID string) ([]Identity, error) {
+	query := "SELECT provider, email, createdAt FROM identities WHERE userID = $1 ORDER BY createdAt"
+
+	identities := []Identity{}
+	rows, err := m.DB.Query(context.Background(), query, userID)
+	if err != nil {
+		return identities, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var identity Identity
+		err = rows.Scan(&identity.Provider, &identity.Email, &identity.CreatedAt)
+		if err != nil {
+			return identities, err
+		}
+
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
+}
+
+// UnlinkIdentity removes the user's identity of the provider. ErrLastLoginMethod is returned if it's the only
+// way the user can log in, that is they have no other identity, never set a password and have no passkey.
+func (m *IdentityModel) UnlinkIdentity(userID, provider string) error {
+	tx, err := m.DB.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	// Locks the user so concurrent unlinks can't each leave the other as the last login method
+	query := `SELECT passwordSet,
+		EXISTS (SELECT 1 FROM identities WHERE userID = $1 AND provider <> $2),
+		EXISTS (SELECT 1 FROM webauthnCredentials WHERE userID = $1)
+		FROM users WHERE userID = $1 FOR UPDATE`
+
+	var passwordSet, otherIdentity, passkey bool
+	err = tx.QueryRow(context.Background(), query, userID, provider).Scan(&passwordSet, &otherIdentity, &passkey)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrUserNotFound
+		}
+
+		return err
+	}
+
+	query = "DELETE FROM identities WHERE userID = $1 AND provider = $2"
+	result, err := tx.Exec(context.Background(), query, userID, provider)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrIdentityNotFound
+	}
+
+	if !passwordSet && !otherIdentity && !passkey {
+		return ErrLastLoginMethod
+	}
+
+	return tx.Commit(context.Background())
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// LoginAttemptModel records every login attempt, both to throttle attackers and for auditing.
+type LoginAttemptModel struct {
+	DB *pgxpool.Pool
+}
+
+// FailureStats summarises the failed attempts that count towards a lockout.
+type FailureStats struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// StartAttempt counts the failures of the email and the IP address and, if allow accepts them, records a new attempt
+// as failed and returns its ID. The email and IP address stay locked until the attempt is recorded, so concurrent
+// attempts are counted one after the other. The ID is empty when allow rejects the attempt, which isn't recorded.
+func (m *LoginAttemptModel) StartAttempt(email, ip string, accountSince, ipSince time.Time, allow func(account, ip FailureStats) bool) (string, error) {
+	tx, err := m.DB.Begin(context.Background())
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(context.Background())
+
+	// Always locked in the same order so two attempts can't wait for each other
+	query := "SELECT pg_advisory_xact_lock(hashtextextended($1, 0)), pg_advisory_xact_lock(hashtextextended($2, 0))"
+	_, err = tx.Exec(context.Background(), query, "loginAttempts:email:"+email, "loginAttempts:ip:"+ip)
+	if err != nil {
+		return "", err
+	}
+
+	accountStats, err := accountFailures(tx, email, accountSince)
+	if err != nil {
+		return "", err
+	}
+
+	ipStats, err := ipFailures(tx, ip, ipSince)
+	if err != nil {
+		return "", err
+	}
+
+	if !allow(accountStats, ipStats) {
+		return "", nil
+	}
+
+	attemptID := internal.GenerateID()
+	query = "INSERT INTO loginAttempts (attemptID, email, ipAddress, succeeded) VALUES ($1, $2, $3, false)"
+
+	_, err = tx.Exec(context.Background(), query, attemptID, email, ip)
+	if err != nil {
+		return "", err
+	}
+
+	return attemptID, tx.Commit(context.Background())
+}
+
+// FinishAttempt stores the outcome of an attempt. userID is empty when the email doesn't belong to any account.
+func (m *LoginAttemptModel) FinishAttempt(attemptID, userID string, succeeded bool) error {
+	query := "UPDATE loginAttempts SET userID = NULLIF($1, ''), succeeded = $2 WHERE attemptID = $3"
+
+	_, err := m.DB.Exec(context.Background(), query, userID, succeeded, attemptID)
+	return err
+}
+
+// DeleteAttemptsBefore deletes the attempts made before the given time.
+func (m *LoginAttemptModel) DeleteAttemptsBefore(before time.Time) error {
+	query := "DELETE FROM loginAttempts WHERE createdAt < $1"
+
+	_, err := m.DB.Exec(context.Background(), query, before)
+	return err
+}
+
+// accountFailures counts the failed attempts for the email since its last successful login, ignoring attempts older than since.
+func accountFailures(q queryer, email string, since time.Time) (FailureStats, error) {
+	query := `SELECT COUNT(*), COALESCE(MAX(createdAt), 'epoch') FROM loginAttempts
+	WHERE email = $1 AND succeeded = false AND createdAt > GREATEST($2, (SELECT MAX(createdAt) FROM loginAttempts WHERE email = $1 AND succeeded = true))`
+
+	var stats FailureStats
+	err := q.QueryRow(context.Background(), query, email, since).Scan(&stats.Failures, &stats.LastFailure)
+	return stats, err
+}
+
+// ipFailures counts the failed attempts made from the IP address since the given time, across every account.
+func ipFailures(q queryer, ip string, since time.Time) (FailureStats, error) {
+	query := "SELECT COUNT(*), COALESCE(MAX(createdAt), 'epoch') FROM loginAttempts WHERE ipAddress = $1 AND succeeded = false AND createdAt > $2"
+
+	var stats FailureStats
+	err := q.QueryRow(context.Background(), query, ip, since).Scan(&stats.Failures, &stats.LastFailure)
+	return stats, err
+}
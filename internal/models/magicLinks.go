@@ -0,0 +1,58 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type MagicLinkModel struct {
+	DB *pgxpool.Pool
+}
+
+var MagicLinkExpirationDelta time.Duration = time.Minute * 15
+
+func (m *MagicLinkModel) tokens() oneTimeTokens {
+	return oneTimeTokens{db: m.DB, table: "magicLinks", expirationDelta: MagicLinkExpirationDelta}
+}
+
+// CreateToken stores the hash of a new login token for the user and invalidates any previous unused one.
+// It returns when the token expires.
+func (m *MagicLinkModel) CreateToken(userID, tokenHash string) (time.Time, error) {
+	return m.tokens().create(userID, tokenHash)
+}
+
+// LastIssuedAt returns when the latest login token was created for the user.
+func (m *MagicLinkModel) LastIssuedAt(userID string) (time.Time, error) {
+	return m.tokens().lastIssuedAt(userID)
+}
+
+// ConsumeToken marks the token as used and returns the ID of the user it was issued for,
+// or ErrInvalidToken if the token is unknown, expired or already used.
+// Opening the link proves the user owns the email, so it's marked as verified.
+func (m *MagicLinkModel) ConsumeToken(tokenHash string) (string, error) {
+	tx, err := m.DB.Begin(context.Background())
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(context.Background())
+
+	userID, err := m.tokens().consume(tx, tokenHash)
+	if err != nil {
+		return "", err
+	}
+
+	query := "UPDATE users SET verified = TRUE WHERE userID = $1 AND NOT verified"
+	_, err = tx.Exec(context.Background(), query, userID)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit(context.Background())
+	if err != nil {
+		return "", err
+	}
+
+	return userID, nil
+}
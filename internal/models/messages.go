@@ -0,0 +1,198 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var DefaultMessageLimit int = 50
+var MaxMessageLimit int = 100
+
+type Message struct {
+	MessageID string     `json:"messageID"`
+	ChannelID string     `json:"channelID"`
+	AuthorID  NullString `json:"authorID"` // Empty once the author deleted their account
+	Content   string     `json:"content"`
+	CreatedAt time.Time  `json:"createdAt"`
+	EditedAt  *time.Time `json:"editedAt"`
+}
+
+// MessageQuery describes a page of messages. At most one of Before, After or Around should be set,
+// when none are set the most recent messages in the channel are returned.
+type MessageQuery struct {
+	Before string
+	After  string
+	Around string
+	Limit  int
+}
+
+type MessageModel struct {
+	DB *pgxpool.Pool
+}
+
+func (m *MessageModel) SendMessage(channelID, authorID, content string) (Message, error) {
+	message := Message{
+		MessageID: internal.GenerateID(),
+		ChannelID: channelID,
+		AuthorID:  NullString(authorID),
+		Content:   content,
+	}
+
+	tx, err := m.DB.Begin(context.Background())
+	if err != nil {
+		return Message{}, err
+	}
+	defer tx.Rollback(context.Background())
+
+	query := "INSERT INTO messages (messageID, channelID, authorID, content) VALUES ($1, $2, $3, $4) RETURNING createdAt"
+	err = tx.QueryRow(context.Background(), query, message.MessageID, channelID, authorID, content).Scan(&message.CreatedAt)
+	if err != nil {
+		return Message{}, err
+	}
+
+	// Bump the channel so it's listed first and show it again to members that had it hidden
+	query = "UPDATE channels SET updatedAt = NOW() WHERE channelID = $1"
+	_, err = tx.Exec(context.Background(), query, channelID)
+	if err != nil {
+		return Message{}, err
+	}
+
+	query = "UPDATE channelMembers SET hidden = false WHERE channelID = $1 AND hidden = true"
+	_, err = tx.Exec(context.Background(), query, channelID)
+	if err != nil {
+		return Message{}, err
+	}
+
+	err = tx.Commit(context.Background())
+	if err != nil {
+		return Message{}, err
+	}
+
+	return message, nil
+}
+
+func (m *MessageModel) FetchMessage(channelID, messageID string) (Message, error) {
+	query := "SELECT messageID, channelID, authorID, content, createdAt, editedAt FROM messages WHERE channelID = $1 AND messageID = $2"
+
+	message := Message{}
+	row := m.DB.QueryRow(context.Background(), query, channelID, messageID)
+	err := row.Scan(&message.MessageID, &message.ChannelID, &message.AuthorID, &message.Content, &message.CreatedAt, &message.EditedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return message, ErrMessageNotFound
+		}
+
+		return message, err
+	}
+
+	return message, nil
+}
+
+// FetchMessages returns a page of messages from the channel ordered from newest to oldest.
+func (m *MessageModel) FetchMessages(channelID string, q MessageQuery) ([]Message, error) {
+	query, args := messagesQuery(channelID, q)
+	return m.queryMessages(query, args...)
+}
+
+// messagesQuery builds the query for a page of messages and its arguments.
+// Message IDs are ULIDs so comparing them is the same as comparing their creation time.
+func messagesQuery(channelID string, q MessageQuery) (string, []any) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultMessageLimit
+	}
+
+	if q.Limit > MaxMessageLimit {
+		q.Limit = MaxMessageLimit
+	}
+
+	const columns = "messageID, channelID, authorID, content, createdAt, editedAt"
+
+	switch {
+	case q.Before != "":
+		query := "SELECT " + columns + " FROM messages WHERE channelID = $1 AND messageID < $2 ORDER BY messageID DESC LIMIT $3"
+		return query, []any{channelID, q.Before, q.Limit}
+	case q.After != "":
+		// Take the messages closest to the cursor and flip them so the order matches the other queries
+		query := "SELECT * FROM (SELECT " + columns + " FROM messages WHERE channelID = $1 AND messageID > $2 ORDER BY messageID ASC LIMIT $3) m ORDER BY messageID DESC"
+		return query, []any{channelID, q.After, q.Limit}
+	case q.Around != "":
+		// Split the limit between both sides of the cursor, the cursor message itself is included in the older half
+		query := `SELECT * FROM (
+					(SELECT ` + columns + ` FROM messages WHERE channelID = $1 AND messageID > $2 ORDER BY messageID ASC LIMIT $3)
+					UNION ALL
+					(SELECT ` + columns + ` FROM messages WHERE channelID = $1 AND messageID <= $2 ORDER BY messageID DESC LIMIT $4)
+				) m ORDER BY messageID DESC`
+		return query, []any{channelID, q.Around, q.Limit / 2, q.Limit - q.Limit/2}
+	default:
+		query := "SELECT " + columns + " FROM messages WHERE channelID = $1 ORDER BY messageID DESC LIMIT $2"
+		return query, []any{channelID, q.Limit}
+	}
+}
+
+func (m *MessageModel) queryMessages(query string, args ...any) ([]Message, error) {
+	rows, err := m.DB.Query(context.Background(), query, args...)
+	if err != nil {
+		return []Message{}, err
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		var message Message
+		err := rows.Scan(&message.MessageID, &message.ChannelID, &message.AuthorID, &message.Content, &message.CreatedAt, &message.EditedAt)
+		if err != nil {
+			return []Message{}, err
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages, rows.Err()
+}
+
+// FetchAuthoredMessages returns up to limit messages the user wrote after the cursor, oldest first.
+// An empty cursor starts from the user's first message.
+func (m *MessageModel) FetchAuthoredMessages(authorID, after string, limit int) ([]Message, error) {
+	query := "SELECT messageID, channelID, authorID, content, createdAt, editedAt FROM messages WHERE authorID = $1 AND messageID > $2 ORDER BY messageID ASC LIMIT $3"
+	return m.queryMessages(query, authorID, after, limit)
+}
+
+// EditMessage replaces the content of a message, only the author of the message can edit it.
+func (m *MessageModel) EditMessage(channelID, messageID, authorID, content string) (Message, error) {
+	query := `UPDATE messages SET content = $1, editedAt = NOW()
+				WHERE channelID = $2 AND messageID = $3 AND authorID = $4
+				RETURNING messageID, channelID, authorID, content, createdAt, editedAt`
+
+	message := Message{}
+	row := m.DB.QueryRow(context.Background(), query, content, channelID, messageID, authorID)
+	err := row.Scan(&message.MessageID, &message.ChannelID, &message.AuthorID, &message.Content, &message.CreatedAt, &message.EditedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return message, ErrMessageNotFound
+		}
+
+		return message, err
+	}
+
+	return message, nil
+}
+
+func (m *MessageModel) DeleteMessage(channelID, messageID string) error {
+	query := "DELETE FROM messages WHERE channelID = $1 AND messageID = $2"
+
+	res, err := m.DB.Exec(context.Background(), query, channelID, messageID)
+	if err != nil {
+		return err
+	}
+
+	if res.RowsAffected() < 1 {
+		return ErrMessageNotFound
+	}
+
+	return nil
+}
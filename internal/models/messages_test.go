@@ -0,0 +1,152 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/migrations"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestMessagesQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     MessageQuery
+		condition string // Comparison with the cursor, empty for the latest messages
+		args      []any
+	}{
+		{"latest", MessageQuery{Limit: 10}, "", []any{"channel", 10}},
+		{"default limit", MessageQuery{}, "", []any{"channel", DefaultMessageLimit}},
+		{"limit capped", MessageQuery{Limit: MaxMessageLimit + 1}, "", []any{"channel", MaxMessageLimit}},
+		{"before", MessageQuery{Before: "cursor", Limit: 10}, "messageID < $2", []any{"channel", "cursor", 10}},
+		{"after", MessageQuery{After: "cursor", Limit: 10}, "messageID > $2", []any{"channel", "cursor", 10}},
+		{"around", MessageQuery{Around: "cursor", Limit: 10}, "messageID <= $2", []any{"channel", "cursor", 5, 5}},
+		{"around odd limit", MessageQuery{Around: "cursor", Limit: 5}, "messageID <= $2", []any{"channel", "cursor", 2, 3}},
+		{"before takes precedence", MessageQuery{Before: "before", After: "after", Limit: 10}, "messageID < $2", []any{"channel", "before", 10}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, args := messagesQuery("channel", test.query)
+
+			if !strings.Contains(query, test.condition) {
+				t.Errorf("query %q doesn't compare %q", query, test.condition)
+			}
+
+			if !reflect.DeepEqual(args, test.args) {
+				t.Errorf("args = %v, want %v", args, test.args)
+			}
+		})
+	}
+}
+
+// newTestDB returns a pool on a schema of its own in TEST_DATABASE_URL with the migrations applied.
+// The test is skipped when TEST_DATABASE_URL isn't set.
+func newTestDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	schema := "models_test_" + strings.ToLower(internal.GenerateID())
+
+	setupPool, err := pgxpool.New(context.Background(), databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(setupPool.Close)
+
+	_, err = setupPool.Exec(context.Background(), "CREATE SCHEMA "+schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		setupPool.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+	})
+
+	config, err := pgxpool.ParseConfig(databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	err = migrations.Apply(context.Background(), pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pool
+}
+
+func TestFetchMessages(t *testing.T) {
+	pool := newTestDB(t)
+	messages := &MessageModel{DB: pool}
+
+	setup := []string{
+		"INSERT INTO users (userID, username, email, password) VALUES ('user', 'ada', 'ada@example.com', 'hash')",
+		"INSERT INTO channels (channelID, channelName, ownerID, channelType) VALUES ('channel', 'general', 'user', 'group')",
+		"INSERT INTO channels (channelID, channelName, ownerID, channelType) VALUES ('other', 'random', 'user', 'group')",
+		"INSERT INTO messages (messageID, channelID, authorID, content) VALUES ('00', 'other', 'user', 'elsewhere')",
+	}
+	for _, query := range setup {
+		_, err := pool.Exec(context.Background(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// IDs sort like ULIDs, 01 is the oldest message and 10 the newest
+	for i := 1; i <= 10; i++ {
+		_, err := pool.Exec(context.Background(), "INSERT INTO messages (messageID, channelID, authorID, content) VALUES ($1, 'channel', 'user', 'hi')", fmt.Sprintf("%02d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query MessageQuery
+		want  []string
+	}{
+		{"latest", MessageQuery{Limit: 3}, []string{"10", "09", "08"}},
+		{"before", MessageQuery{Before: "05", Limit: 3}, []string{"04", "03", "02"}},
+		{"before the start", MessageQuery{Before: "02", Limit: 3}, []string{"01"}},
+		{"after", MessageQuery{After: "05", Limit: 3}, []string{"08", "07", "06"}},
+		{"after the end", MessageQuery{After: "10", Limit: 3}, []string{}},
+		{"around", MessageQuery{Around: "05", Limit: 4}, []string{"07", "06", "05", "04"}},
+		{"around odd limit", MessageQuery{Around: "05", Limit: 3}, []string{"06", "05", "04"}},
+		{"around the newest", MessageQuery{Around: "10", Limit: 4}, []string{"10", "09"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page, err := messages.FetchMessages("channel", test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []string{}
+			for _, message := range page {
+				got = append(got, message.MessageID)
+			}
+
+			if !slices.Equal(got, test.want) {
+				t.Fatalf("FetchMessages = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type MFAModel struct {
+	DB *pgxpool.Pool
+}
+
+// MFAChallenge is a login that passed the password check and is waiting for a second factor.
+type MFAChallenge struct {
+	ChallengeID string
+	UserID      string
+	Platform    string
+	OS          string
+}
+
+var MFAChallengeExpirationDelta time.Duration = time.Minute * 5
+
+// Codes that can be tried against a single challenge before a new login is required
+var MaxMFAChallengeAttempts int = 5
+
+type TOTPEnrollment struct {
+	Secret       string
+	Enabled      bool
+	LastUsedStep int64
+}
+
+// IsEnabled reports whether the user has to provide a second factor when logging in.
+func (m *MFAModel) IsEnabled(userID string) (bool, error) {
+	query := "SELECT enabled FROM userMFA WHERE userID = $1"
+
+	var enabled bool
+	err := m.DB.QueryRow(context.Background(), query, userID).Scan(&enabled)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return enabled, nil
+}
+
+// StartEnrollment stores a TOTP secret that stays inactive until the user confirms it with a first code.
+// Starting again replaces the pending secret.
+func (m *MFAModel) StartEnrollment(userID, secret string) error {
+	query := `INSERT INTO userMFA (userID, secret, enabled) VALUES ($1, $2, false)
+	ON CONFLICT (userID) DO UPDATE SET secret = EXCLUDED.secret, lastUsedStep = 0, createdAt = NOW() WHERE userMFA.enabled = false`
+
+	res, err := m.DB.Exec(context.Background(), query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	if res.RowsAffected() < 1 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	return nil
+}
+
+func (m *MFAModel) FetchEnrollment(userID string) (TOTPEnrollment, error) {
+	query := "SELECT secret, enabled, lastUsedStep FROM userMFA WHERE userID = $1"
+
+	var enrollment TOTPEnrollment
+	err := m.DB.QueryRow(context.Background(), query, userID).Scan(&enrollment.Secret, &enrollment.Enabled, &enrollment.LastUsedStep)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return enrollment, ErrMFANotEnrolled
+		}
+
+		return enrollment, err
+	}
+
+	return enrollment, nil
+}
+
+// Enable activates the pending secret and replaces the user's recovery codes.
+func (m *MFAModel) Enable(userID string, step int64, recoveryCodeHashes []string) error {
+	tx, err := m.DB.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	query := "UPDATE userMFA SET enabled = true, lastUsedStep = $2 WHERE userID = $1 AND enabled = false"
+	res, err := tx.Exec(context.Background(), query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	if res.RowsAffected() < 1 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	err = replaceRecoveryCodes(tx, userID, recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+func (m *MFAModel) Disable(userID string) error {
+	tx, err := m.DB.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	query := "DELETE FROM mfaRecoveryCodes WHERE userID = $1"
+	_, err = tx.Exec(context.Background(), query, userID)
+	if err != nil {
+		return err
+	}
+
+	query = "DELETE FROM userMFA WHERE userID = $1"
+	_, err = tx.Exec(context.Background(), query, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// UseStep records that the code for the given step was used, so the same code can't be replayed.
+// It returns false if a code for this step or a later one was already accepted.
+func (m *MFAModel) UseStep(userID string, step int64) (bool, error) {
+	query := "UPDATE userMFA SET lastUsedStep = $2 WHERE userID = $1 AND enabled = true AND lastUsedStep < $2"
+
+	res, err := m.DB.Exec(context.Background(), query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	return res.RowsAffected() > 0, nil
+}
+
+// UseRecoveryCode consumes a recovery code, each of them works only once.
+func (m *MFAModel) UseRecoveryCode(userID, codeHash string) (bool, error) {
+	query := "UPDATE mfaRecoveryCodes SET usedAt = NOW() WHERE userID = $1 AND codeHash = $2 AND usedAt IS NULL"
+
+	res, err := m.DB.Exec(context.Background(), query, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	return res.RowsAffected() > 0, nil
+}
+
+func (m *MFAModel) ReplaceRecoveryCodes(userID string, recoveryCodeHashes []string) error {
+	tx, err := m.DB.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	err = replaceRecoveryCodes(tx, userID, recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+func replaceRecoveryCodes(tx pgx.Tx, userID string, recoveryCodeHashes []string) error {
+	query := "DELETE FROM mfaRecoveryCodes WHERE userID = $1"
+	_, err := tx.Exec(context.Background(), query, userID)
+	if err != nil {
+		return err
+	}
+
+	query = "INSERT INTO mfaRecoveryCodes (userID, codeHash) SELECT $1, unnest($2::text[])"
+	_, err = tx.Exec(context.Background(), query, userID, recoveryCodeHashes)
+	return err
+}
+
+// CreateChallenge stores a pending login for the user. Only the hash of the token handed to the client is stored.
+func (m *MFAModel) CreateChallenge(userID, tokenHash, platform, os string) error {
+	query := "INSERT INTO mfaChallenges (challengeID, userID, tokenHash, platform, os, expiresAt) VALUES ($1, $2, $3, $4, $5, $6)"
+
+	_, err := m.DB.Exec(context.Background(), query, internal.GenerateID(), userID, tokenHash, platform, os, time.Now().Add(MFAChallengeExpirationDelta))
+	return err
+}
+
+// AttemptChallenge counts an attempt against the challenge and returns it.
+// Expired challenges and challenges that ran out of attempts return ErrInvalidToken.
+func (m *MFAModel) AttemptChallenge(tokenHash string) (MFAChallenge, error) {
+	query := `UPDATE mfaChallenges SET attempts = attempts + 1
+	WHERE tokenHash = $1 AND expiresAt > NOW() AND attempts < $2
+	RETURNING challengeID, userID, platform, os`
+
+	var challenge MFAChallenge
+	err := m.DB.QueryRow(context.Background(), query, tokenHash, MaxMFAChallengeAttempts).Scan(&challenge.ChallengeID, &challenge.UserID, &challenge.Platform, &challenge.OS)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return challenge, ErrInvalidToken
+		}
+
+		return challenge, err
+	}
+
+	return challenge, nil
+}
+
+// CompleteChallenge deletes the challenge once the second factor was accepted, along with any expired ones.
+func (m *MFAModel) CompleteChallenge(challengeID string) error {
+	query := "DELETE FROM mfaChallenges WHERE challengeID = $1 OR expiresAt <= NOW()"
+
+	_, err := m.DB.Exec(context.Background(), query, challengeID)
+	return err
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// oneTimeTokens stores the hashes of single use tokens emailed to users. Each kind of token has a table of its own,
+// all of them with the columns tokenID, userID, tokenHash, expiresAt, usedAt and createdAt.
+type oneTimeTokens struct {
+	db              *pgxpool.Pool
+	table           string
+	expirationDelta time.Duration
+}
+
+// create stores the hash of a new token for the user, invalidates any previous unused one and returns when it expires.
+func (t oneTimeTokens) create(userID, tokenHash string) (time.Time, error) {
+	tx, err := t.db.Begin(context.Background())
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback(context.Background())
+
+	query := "DELETE FROM " + t.table + " WHERE userID = $1 AND usedAt IS NULL"
+	_, err = tx.Exec(context.Background(), query, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(t.expirationDelta)
+	query = "INSERT INTO " + t.table + " (tokenID, userID, tokenHash, expiresAt) VALUES ($1, $2, $3, $4)"
+	_, err = tx.Exec(context.Background(), query, internal.GenerateID(), userID, tokenHash, expiresAt)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return expiresAt, tx.Commit(context.Background())
+}
+
+// lastIssuedAt returns when the latest token was created for the user, the zero time if there's none.
+func (t oneTimeTokens) lastIssuedAt(userID string) (time.Time, error) {
+	query := "SELECT createdAt FROM " + t.table + " WHERE userID = $1 ORDER BY createdAt DESC LIMIT 1"
+
+	var createdAt time.Time
+	err := t.db.QueryRow(context.Background(), query, userID).Scan(&createdAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, nil
+		}
+
+		return time.Time{}, err
+	}
+
+	return createdAt, nil
+}
+
+// consume marks the token as used as part of tx and returns the ID of the user it was issued for,
+// or ErrInvalidToken if the token is unknown, expired or already used.
+func (t oneTimeTokens) consume(tx pgx.Tx, tokenHash string) (string, error) {
+	query := "UPDATE " + t.table + " SET usedAt = NOW() WHERE tokenHash = $1 AND usedAt IS NULL AND expiresAt > NOW() RETURNING userID"
+
+	var userID string
+	err := tx.QueryRow(context.Background(), query, tokenHash).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrInvalidToken
+		}
+
+		return "", err
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,214 @@
+package models
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	CeremonyRegistration = "registration"
+	CeremonyLogin        = "login"
+)
+
+// Time a client has to complete a WebAuthn ceremony when the library doesn't set one
+var CeremonyExpirationDelta time.Duration = time.Minute * 5
+
+type Passkey struct {
+	CredentialID string     `json:"credentialID"` // Base64 URL encoded
+	Name         string     `json:"name"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	LastUsedAt   *time.Time `json:"lastUsedAt"`
+}
+
+// Ceremony is a WebAuthn registration or login waiting for the authenticator's response.
+type Ceremony struct {
+	CeremonyID string
+	UserID     string // Empty for passkey logins, the user is only known once the authenticator answers
+	Platform   string
+	OS         string
+	Data       webauthn.SessionData
+}
+
+type PasskeyModel struct {
+	DB *pgxpool.Pool
+}
+
+func (m *PasskeyModel) InsertCredential(userID, name string, credential webauthn.Credential) (Passkey, error) {
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return Passkey{}, err
+	}
+
+	query := "INSERT INTO webauthnCredentials (credentialID, userID, name, credential, signCount) VALUES ($1, $2, $3, $4, $5) RETURNING createdAt"
+
+	passkey := Passkey{CredentialID: base64.RawURLEncoding.EncodeToString(credential.ID), Name: name}
+	err = m.DB.QueryRow(context.Background(), query, credential.ID, userID, name, data, credential.Authenticator.SignCount).Scan(&passkey.CreatedAt)
+	if err != nil {
+		var pgError *pgconn.PgError
+		if errors.As(err, &pgError) && pgError.Code == "23505" {
+			return Passkey{}, ErrPasskeyExists
+		}
+
+		return Passkey{}, err
+	}
+
+	return passkey, nil
+}
+
+// FetchCredentials returns every credential the user registered, with their latest sign counters.
+func (m *PasskeyModel) FetchCredentials(userID string) ([]webauthn.Credential, error) {
+	query := "SELECT credential, signCount FROM webauthnCredentials WHERE userID = $1"
+
+	rows, err := m.DB.Query(context.Background(), query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []webauthn.Credential{}
+	for rows.Next() {
+		var data []byte
+		var signCount int64
+
+		err = rows.Scan(&data, &signCount)
+		if err != nil {
+			return nil, err
+		}
+
+		var credential webauthn.Credential
+		err = json.Unmarshal(data, &credential)
+		if err != nil {
+			return nil, err
+		}
+		credential.Authenticator.SignCount = uint32(signCount)
+
+		credentials = append(credentials, credential)
+	}
+
+	return credentials, rows.Err()
+}
+
+func (m *PasskeyModel) FetchPasskeys(userID string) ([]Passkey, error) {
+	query := "SELECT credentialID, name, createdAt, lastUsedAt FROM webauthnCredentials WHERE userID = $1 ORDER BY createdAt"
+
+	rows, err := m.DB.Query(context.Background(), query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passkeys := []Passkey{}
+	for rows.Next() {
+		var passkey Passkey
+		var credentialID []byte
+		err = rows.Scan(&credentialID, &passkey.Name, &passkey.CreatedAt, &passkey.LastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		passkey.CredentialID = base64.RawURLEncoding.EncodeToString(credentialID)
+
+		passkeys = append(passkeys, passkey)
+	}
+
+	return passkeys, rows.Err()
+}
+
+// UpdateAfterLogin saves the sign counter and flags reported by the authenticator during a login.
+func (m *PasskeyModel) UpdateAfterLogin(credential webauthn.Credential) error {
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE webauthnCredentials SET credential = $2, signCount = $3, lastUsedAt = NOW() WHERE credentialID = $1"
+
+	_, err = m.DB.Exec(context.Background(), query, credential.ID, data, credential.Authenticator.SignCount)
+	return err
+}
+
+func (m *PasskeyModel) DeleteCredential(userID string, credentialID []byte) error {
+	query := "DELETE FROM webauthnCredentials WHERE userID = $1 AND credentialID = $2"
+
+	res, err := m.DB.Exec(context.Background(), query, userID, credentialID)
+	if err != nil {
+		return err
+	}
+
+	if res.RowsAffected() < 1 {
+		return ErrPasskeyNotFound
+	}
+
+	return nil
+}
+
+// CreateCeremony stores the state of a ceremony until the client sends the authenticator's response.
+func (m *PasskeyModel) CreateCeremony(kind, userID, platform, os string, data webauthn.SessionData) (string, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := data.Expires
+	if expiresAt.IsZero() {
+		expiresAt = time.Now().Add(CeremonyExpirationDelta)
+	}
+
+	ceremonyID := internal.GenerateID()
+	query := "INSERT INTO webauthnCeremonies (ceremonyID, kind, userID, platform, os, data, expiresAt) VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7)"
+
+	_, err = m.DB.Exec(context.Background(), query, ceremonyID, kind, userID, platform, os, payload, expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	return ceremonyID, nil
+}
+
+// ConsumeCeremony removes the ceremony and returns it, so each challenge can only be answered once.
+// Expired or unknown ceremonies return ErrInvalidToken.
+func (m *PasskeyModel) ConsumeCeremony(ceremonyID, kind string) (Ceremony, error) {
+	query := "DELETE FROM webauthnCeremonies WHERE ceremonyID = $1 AND kind = $2 RETURNING userID, platform, os, data, expiresAt"
+
+	ceremony := Ceremony{CeremonyID: ceremonyID}
+
+	var userID NullString
+	var payload []byte
+	var expiresAt time.Time
+	err := m.DB.QueryRow(context.Background(), query, ceremonyID, kind).Scan(&userID, &ceremony.Platform, &ceremony.OS, &payload, &expiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ceremony, ErrInvalidToken
+		}
+
+		return ceremony, err
+	}
+
+	if expiresAt.Before(time.Now()) {
+		return ceremony, ErrInvalidToken
+	}
+
+	ceremony.UserID = string(userID)
+
+	err = json.Unmarshal(payload, &ceremony.Data)
+	if err != nil {
+		return ceremony, err
+	}
+
+	// Clean up ceremonies clients never finished
+	query = "DELETE FROM webauthnCeremonies WHERE expiresAt <= NOW()"
+	_, err = m.DB.Exec(context.Background(), query)
+	if err != nil {
+		return ceremony, err
+	}
+
+	return ceremony, nil
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/password"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PasswordResetModel struct {
+	DB *pgxpool.Pool
+}
+
+var PasswordResetExpirationDelta time.Duration = time.Minute * 30
+
+func (m *PasswordResetModel) tokens() oneTimeTokens {
+	return oneTimeTokens{db: m.DB, table: "passwordResets", expirationDelta: PasswordResetExpirationDelta}
+}
+
+// CreateToken stores the hash of a new reset token for the user and invalidates any previous unused one.
+// It returns when the token expires.
+func (m *PasswordResetModel) CreateToken(userID, tokenHash string) (time.Time, error) {
+	return m.tokens().create(userID, tokenHash)
+}
+
+// LastIssuedAt returns when the latest reset token was created for the user.
+func (m *PasswordResetModel) LastIssuedAt(userID string) (time.Time, error) {
+	return m.tokens().lastIssuedAt(userID)
+}
+
+// ResetPassword consumes the token, replaces the password of the user it was issued for and ends all of their sessions.
+// It returns the ID of that user, or ErrInvalidToken if the token is unknown, expired or already used.
+func (m *PasswordResetModel) ResetPassword(tokenHash, newPassword string) (string, error) {
+	hashedPassword, err := password.Hash(newPassword)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := m.DB.Begin(context.Background())
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(context.Background())
+
+	userID, err := m.tokens().consume(tx, tokenHash)
+	if err != nil {
+		return "", err
+	}
+
+	query := "UPDATE users SET password = $1, passwordSet = TRUE, updatedAt = NOW() WHERE userID = $2"
+	_, err = tx.Exec(context.Background(), query, hashedPassword, userID)
+	if err != nil {
+		return "", err
+	}
+
+	// Whoever knew the old password must not stay logged in
+	err = deleteAllSessions(tx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit(context.Background())
+	if err != nil {
+		return "", err
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// How long a connection counts towards its user's presence without its instance refreshing it.
+// Connections of an instance that stopped without closing them are dropped once it runs out.
+var PresenceTTL time.Duration = time.Second * 90
+
+// PresenceChange is the user's aggregate status after a change to one of their connections.
+type PresenceChange struct {
+	Status   string // Empty when the user has no connection left
+	Previous string // The status published before the change, empty if the user was offline
+}
+
+func (c PresenceChange) Changed() bool {
+	return c.Status != c.Previous
+}
+
+// PresenceModel keeps the status of every gateway connection, on every instance of the API, so presence
+// can be aggregated across all of a user's devices no matter which instance they're connected to.
+type PresenceModel struct {
+	DB *pgxpool.Pool
+}
+
+// SetConnectionStatus stores the status of a connection and returns the user's new aggregate status,
+// computed by aggregate from the status of each of their connections.
+func (m *PresenceModel) SetConnectionStatus(userID, connectionID, instanceID, status string, aggregate func(statuses []string) string) (PresenceChange, error) {
+	return m.changePresence(userID, aggregate, func(tx pgx.Tx) error {
+		query := `INSERT INTO presenceConnections (connectionID, userID, instanceID, status, expiresAt) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (connectionID) DO UPDATE SET status = EXCLUDED.status, expiresAt = EXCLUDED.expiresAt`
+
+		_, err := tx.Exec(context.Background(), query, connectionID, userID, instanceID, status, time.Now().Add(PresenceTTL))
+		return err
+	})
+}
+
+// RemoveConnection forgets a closed connection and returns the user's new aggregate status.
+func (m *PresenceModel) RemoveConnection(userID, connectionID string, aggregate func(statuses []string) string) (PresenceChange, error) {
+	return m.changePresence(userID, aggregate, func(tx pgx.Tx) error {
+		query := "DELETE FROM presenceConnections WHERE connectionID = $1"
+
+		_, err := tx.Exec(context.Background(), query, connectionID)
+		return err
+	})
+}
+
+// RecomputePresence aggregates the user's status again, used once some of their connections expired.
+func (m *PresenceModel) RecomputePresence(userID string, aggregate func(statuses []string) string) (PresenceChange, error) {
+	return m.changePresence(userID, aggregate, func(tx pgx.Tx) error {
+		return nil
+	})
+}
+
+// changePresence applies the change and compares the new aggregate status with the last published one.
+// Changes of the same user are serialized across instances so every transition is reported exactly once.
+func (m *PresenceModel) changePresence(userID string, aggregate func(statuses []string) string, change func(tx pgx.Tx) error) (PresenceChange, error) {
+	tx, err := m.DB.Begin(context.Background())
+	if err != nil {
+		return PresenceChange{}, err
+	}
+	defer tx.Rollback(context.Background())
+
+	query := "SELECT pg_advisory_xact_lock(hashtextextended($1, 0))"
+	_, err = tx.Exec(context.Background(), query, "presences:"+userID)
+	if err != nil {
+		return PresenceChange{}, err
+	}
+
+	err = change(tx)
+	if err != nil {
+		return PresenceChange{}, err
+	}
+
+	query = "SELECT status FROM presenceConnections WHERE userID = $1 AND expiresAt > NOW()"
+	rows, err := tx.Query(context.Background(), query, userID)
+	if err != nil {
+		return PresenceChange{}, err
+	}
+
+	statuses, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return PresenceChange{}, err
+	}
+
+	var presence PresenceChange
+	if len(statuses) > 0 {
+		presence.Status = aggregate(statuses)
+	}
+
+	var previous NullString
+	query = "SELECT status FROM presences WHERE userID = $1"
+	err = tx.QueryRow(context.Background(), query, userID).Scan(&previous)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return PresenceChange{}, err
+	}
+	presence.Previous = string(previous)
+
+	if presence.Changed() {
+		if presence.Status == "" {
+			query = "DELETE FROM presences WHERE userID = $1"
+			_, err = tx.Exec(context.Background(), query, userID)
+		} else {
+			query = `INSERT INTO presences (userID, status, updatedAt) VALUES ($1, $2, NOW())
+				ON CONFLICT (userID) DO UPDATE SET status = EXCLUDED.status, updatedAt = NOW()`
+			_, err = tx.Exec(context.Background(), query, userID, presence.Status)
+		}
+
+		if err != nil {
+			return PresenceChange{}, err
+		}
+	}
+
+	return presence, tx.Commit(context.Background())
+}
+
+// RefreshInstance keeps the connections of the instance from expiring.
+func (m *PresenceModel) RefreshInstance(instanceID string) error {
+	query := "UPDATE presenceConnections SET expiresAt = $1 WHERE instanceID = $2"
+
+	_, err := m.DB.Exec(context.Background(), query, time.Now().Add(PresenceTTL), instanceID)
+	return err
+}
+
+// RemoveExpiredConnections deletes the connections whose instance stopped refreshing them and returns their users,
+// whose presence has to be recomputed.
+func (m *PresenceModel) RemoveExpiredConnections() ([]string, error) {
+	query := "WITH expired AS (DELETE FROM presenceConnections WHERE expiresAt <= NOW() RETURNING userID) SELECT DISTINCT userID FROM expired"
+
+	rows, err := m.DB.Query(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// FetchPresences returns the published status of every user in userIDs that is connected.
+func (m *PresenceModel) FetchPresences(userIDs []string) (map[string]string, error) {
+	query := "SELECT userID, status FROM presences WHERE userID = ANY($1)"
+
+	presences := make(map[string]string)
+	rows, err := m.DB.Query(context.Background(), query, userIDs)
+	if err != nil {
+		return presences, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID, status string
+		err = rows.Scan(&userID, &status)
+		if err != nil {
+			return presences, err
+		}
+
+		presences[userID] = status
+	}
+
+	return presences, rows.Err()
+}
@@ -139,9 +139,9 @@ func (m *RelationshipModel) FetchBlockedUsers(userID string) ([]BlockedUserDTO,
 	return blockedUsers, nil
 }
 
-func (m *RelationshipModel) SetRelationship(userA, userB string) (string, error) {
+func (m *RelationshipModel) SetRelationship(userA, userB string) (string, Channel, error) {
 	if userA == userB {
-		return "", ErrSameUser
+		return "", Channel{}, ErrSameUser
 	}
 
 	// First check if the user can add more friends
@@ -149,11 +149,11 @@ func (m *RelationshipModel) SetRelationship(userA, userB string) (string, error)
 	query := "SELECT COUNT(*) FROM relationships WHERE userA = $1 AND status = 'accepted'"
 	err := m.DB.QueryRow(context.Background(), query, userA).Scan(&count)
 	if err != nil {
-		return "", err
+		return "", Channel{}, err
 	}
 
 	if count >= 2500 {
-		return "", ErrMaxFriends
+		return "", Channel{}, ErrMaxFriends
 	}
 
 	query = "SELECT * FROM relationships WHERE userA = $1 AND userB = $2"
@@ -169,19 +169,19 @@ func (m *RelationshipModel) SetRelationship(userA, userB string) (string, error)
 		if errors.Is(err, pgx.ErrNoRows) {
 			status = "pending"
 		} else {
-			return "", err
+			return "", Channel{}, err
 		}
 	}
 
 	// Check if the recipient has blocked the sender
 	if r.Status == "blocked" {
-		return "", ErrRecipientHasBlockedUser
+		return "", Channel{}, ErrRecipientHasBlockedUser
 	}
 
 	// Start a transaction
 	tx, err := m.DB.Begin(context.Background())
 	if err != nil {
-		return "", err
+		return "", Channel{}, err
 	}
 
 	// Insert the new relationship
@@ -193,18 +193,18 @@ func (m *RelationshipModel) SetRelationship(userA, userB string) (string, error)
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) {
 			if pgErr.Code == "23505" { // Unique constraint violation because the relationship already exists
-				return "REQUEST_ALREADY_SENT", nil
+				return "REQUEST_ALREADY_SENT", Channel{}, nil
 			}
 
 			if pgErr.Code == "23503" { // Foreign key constraint violation due to user not found
-				return "", ErrUserNotFound
+				return "", Channel{}, ErrUserNotFound
 			}
 
 			if pgErr.Code == "23505" && status == "accepted" { // If a row exists betwen the sender and recipient and the status is accepted it means the relationship already exists
-				return "", ErrRelationshipExists
+				return "", Channel{}, ErrRelationshipExists
 			}
 		}
-		return "", err
+		return "", Channel{}, err
 	}
 
 	// If the relationship was accepted, update the other side of the relationship
@@ -214,17 +214,27 @@ func (m *RelationshipModel) SetRelationship(userA, userB string) (string, error)
 		_, err = tx.Exec(context.Background(), query, userB, userA)
 		if err != nil {
 			tx.Rollback(context.Background())
-			return "", err
+			return "", Channel{}, err
 		}
 
-		tx.Commit(context.Background())
+		// Both users are now friends, open a direct message channel between them
+		channel, err := createDMChannel(tx, userA, userB)
+		if err != nil {
+			tx.Rollback(context.Background())
+			return "", Channel{}, err
+		}
+
+		err = tx.Commit(context.Background())
+		if err != nil {
+			return "", Channel{}, err
+		}
 
-		return "REQUEST_ACCEPTED", nil
+		return "REQUEST_ACCEPTED", channel, nil
 	}
 
 	tx.Commit(context.Background())
 
-	return "REQUEST_SENT", nil
+	return "REQUEST_SENT", Channel{}, nil
 }
 
 func (m *RelationshipModel) DeleteRelationship(userA, userB string) error {
@@ -2,24 +2,40 @@ package models
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
-	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Session struct {
-	SessionID    string
-	UserID       string
-	RefreshToken string
-	IpAddress    string
-	Platform     string
-	OS           string
-	ExpiresAt    time.Time
-	UpdatedAt    time.Time
+	SessionID        string
+	UserID           string
+	RefreshTokenHash string
+	RefreshCounter   int64
+	PreviousHashes   []string // Hashes of the tokens the current one replaced, oldest first
+	IpAddress        string
+	Platform         string
+	OS               string
+	ExpiresAt        time.Time
+	RotatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+type SessionDTO struct {
+	SessionID string    `json:"sessionID"`
+	IpAddress string    `json:"ipAddress"`
+	Platform  string    `json:"platform"`
+	OS        string    `json:"os"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Current   bool      `json:"current"` // Whether this is the session making the request
 }
 
 type NewSession struct {
@@ -34,70 +50,153 @@ type SessionsModel struct {
 
 var RefreshTokenExpirationDelta time.Duration = time.Hour * 24 * 30
 
-func generateRefreshToken() (string, time.Time) {
-	return uuid.New().String(), time.Now().Add(RefreshTokenExpirationDelta)
+// Time after a rotation during which the previous refresh token still returns the current one.
+// Clients refreshing from several tabs at once would otherwise look like a stolen token.
+var RefreshTokenGracePeriod time.Duration = time.Second * 30
+
+// Number of rotated refresh tokens remembered per session to recognise replays,
+// older ones are rejected as invalid without ending the session
+const refreshTokenHistory = 100
+
+// Every session is a refresh token family. Each rotation increments the session's counter and issues a new
+// random token "n.<random>". Only hashes are stored, the current token's and the ones it replaced, so the server
+// can tell a token that was genuinely issued for the session but already rotated (a replay) from one that was
+// never valid.
+func newRefreshToken(counter int64) (string, error) {
+	random, err := internal.GenerateSecureToken()
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(counter, 10) + "." + random, nil
 }
 
-func (m *SessionsModel) NewSession(userID, platform, os, ip string) (NewSession, error) {
-	sessionID := internal.GenerateID()
+// refreshTokenCounter returns the counter the token claims to be issued for.
+func refreshTokenCounter(token string) (int64, bool) {
+	counterStr, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, false
+	}
 
-	newSession := NewSession{
-		UserID:    userID,
-		SessionID: sessionID,
+	counter, err := strconv.ParseInt(counterStr, 10, 64)
+	if err != nil || counter < 0 {
+		return 0, false
 	}
 
-	refreshToken, expiresAt := generateRefreshToken()
-	newSession.RefreshToken = refreshToken
+	return counter, true
+}
+
+func (m *SessionsModel) NewSession(userID, platform, os, ip string) (NewSession, error) {
+	sessionID := internal.GenerateID()
+	token, err := newRefreshToken(0)
+	if err != nil {
+		return NewSession{}, err
+	}
 
 	// Insert session in db
-	query := "INSERT INTO sessions (sessionID, userID, refreshToken, ipAddress, platform, os, expiresAt) VALUES ($1, $2, $3, $4, $5, $6, $7)"
-	_, err := m.DB.Exec(context.Background(), query, sessionID, userID, refreshToken, ip, platform, os, expiresAt)
+	query := "INSERT INTO sessions (sessionID, userID, refreshTokenHash, refreshCounter, previousTokenHashes, ipAddress, platform, os, expiresAt, rotatedAt) VALUES ($1, $2, $3, 0, '{}', $4, $5, $6, $7, NOW())"
+	_, err = m.DB.Exec(context.Background(), query, sessionID, userID, internal.HashToken(token), ip, platform, os, time.Now().Add(RefreshTokenExpirationDelta))
 	if err != nil {
 		return NewSession{}, err
 	}
 
-	return newSession, nil
+	return NewSession{
+		UserID:       userID,
+		SessionID:    sessionID,
+		RefreshToken: token,
+	}, nil
 }
 
-func (m *SessionsModel) RevalidateSession(sessionID string, refreshToken string) (NewSession, error) {
-	query := "SELECT * FROM sessions WHERE sessionID = $1"
-
-	row := m.DB.QueryRow(context.Background(), query, sessionID)
+func (m *SessionsModel) fetchSession(sessionID string) (Session, error) {
+	query := "SELECT sessionID, userID, refreshTokenHash, refreshCounter, COALESCE(previousTokenHashes, '{}'), ipAddress, platform, os, expiresAt, rotatedAt, updatedAt FROM sessions WHERE sessionID = $1"
 
 	session := Session{}
-	err := row.Scan(&session.SessionID, &session.UserID, &session.RefreshToken, &session.IpAddress, &session.Platform, &session.OS, &session.ExpiresAt, &session.UpdatedAt)
+	err := m.DB.QueryRow(context.Background(), query, sessionID).Scan(&session.SessionID, &session.UserID, &session.RefreshTokenHash, &session.RefreshCounter, &session.PreviousHashes,
+		&session.IpAddress, &session.Platform, &session.OS, &session.ExpiresAt, &session.RotatedAt, &session.UpdatedAt)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return NewSession{}, ErrSessionExpired
+			return session, ErrSessionExpired
 		}
 
-		return NewSession{}, err
+		return session, err
 	}
 
-	if session.ExpiresAt.Unix() <= time.Now().Unix() {
-		return NewSession{}, ErrSessionExpired
+	return session, nil
+}
+
+// RevalidateSession rotates the session's refresh token. Presenting a token that was already rotated
+// outside of the grace period is treated as theft and ends the session with ErrRefreshTokenReused.
+func (m *SessionsModel) RevalidateSession(sessionID string, presentedToken string) (NewSession, error) {
+	presentedHash := internal.HashToken(presentedToken)
+
+	// Concurrent refreshes of the same session race to rotate it, the ones that lose try again
+	for range 3 {
+		session, err := m.fetchSession(sessionID)
+		if err != nil {
+			return NewSession{}, err
+		}
+
+		if session.ExpiresAt.Unix() <= time.Now().Unix() {
+			return NewSession{}, ErrSessionExpired
+		}
+
+		current := subtle.ConstantTimeCompare([]byte(presentedHash), []byte(session.RefreshTokenHash)) == 1
+		if !current && !slices.Contains(session.PreviousHashes, presentedHash) {
+			return NewSession{}, ErrInvalidSession
+		}
+
+		// The token immediately before the current one, used right after it was rotated. Usually the same client
+		// refreshing from several tabs at once, they get a new token too instead of losing the session.
+		previous := session.PreviousHashes[len(session.PreviousHashes)-1:]
+		counter, _ := refreshTokenCounter(presentedToken)
+		inGracePeriod := len(previous) == 1 && previous[0] == presentedHash &&
+			counter == session.RefreshCounter-1 && time.Since(session.RotatedAt) <= RefreshTokenGracePeriod
+
+		if !current && !inGracePeriod {
+			// An old token was replayed, whoever holds the family can't be trusted anymore
+			err = m.DeleteSession(sessionID)
+			if err != nil && !errors.Is(err, ErrNoSessionsFound) {
+				return NewSession{}, err
+			}
+
+			return NewSession{}, ErrRefreshTokenReused
+		}
+
+		newSession, rotated, err := m.rotate(session)
+		if err != nil || rotated {
+			return newSession, err
+		}
 	}
 
-	// Verify if the refresh token matches the one store of the sessions table
-	if refreshToken != session.RefreshToken {
-		// Clear all session for the user as a security measure
-		m.DeleteAllSessions(session.UserID)
-		return NewSession{}, ErrInvalidSession
+	return NewSession{}, ErrInvalidSession
+}
+
+// rotate issues the next token of the family. It returns false if the token was rotated concurrently.
+func (m *SessionsModel) rotate(session Session) (NewSession, bool, error) {
+	counter := session.RefreshCounter + 1
+	token, err := newRefreshToken(counter)
+	if err != nil {
+		return NewSession{}, false, err
 	}
 
-	// Generate new refresh token and save
-	newRefreshToken, expiresAt := generateRefreshToken()
-	query = "UPDATE sessions SET refreshToken = $1, expiresAt = $2 WHERE sessionID = $3"
-	_, err = m.DB.Exec(context.Background(), query, newRefreshToken, expiresAt, sessionID)
+	// The replaced hash is appended to the history, keeping only the most recent ones
+	query := `UPDATE sessions SET refreshTokenHash = $1, refreshCounter = $2, expiresAt = $3, rotatedAt = NOW(), updatedAt = NOW(),
+		previousTokenHashes = (array_append(COALESCE(previousTokenHashes, '{}'), refreshTokenHash))[cardinality(COALESCE(previousTokenHashes, '{}')) + 2 - $6:]
+	WHERE sessionID = $4 AND refreshCounter = $5`
+	res, err := m.DB.Exec(context.Background(), query, internal.HashToken(token), counter, time.Now().Add(RefreshTokenExpirationDelta), session.SessionID, session.RefreshCounter, refreshTokenHistory)
 	if err != nil {
-		return NewSession{}, err
+		return NewSession{}, false, err
+	}
+
+	if res.RowsAffected() < 1 {
+		return NewSession{}, false, nil
 	}
 
 	return NewSession{
 		UserID:       session.UserID,
 		SessionID:    session.SessionID,
-		RefreshToken: newRefreshToken,
-	}, nil
+		RefreshToken: token,
+	}, true, nil
 }
 
 func (m *SessionsModel) DeleteSession(sessionID string) error {
@@ -116,12 +215,82 @@ func (m *SessionsModel) DeleteSession(sessionID string) error {
 }
 
 func (m *SessionsModel) DeleteAllSessions(userID string) error {
+	return deleteAllSessions(m.DB, userID)
+}
+
+// deleteAllSessions ends every session of the user, inside a transaction when q is one.
+func deleteAllSessions(q queryer, userID string) error {
 	query := "DELETE FROM sessions WHERE userID = $1"
 
-	_, err := m.DB.Exec(context.Background(), query, userID)
+	_, err := q.Exec(context.Background(), query, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// IsActive reports whether the session exists, belongs to the user and hasn't expired.
+func (m *SessionsModel) IsActive(userID, sessionID string) (bool, error) {
+	query := "SELECT EXISTS (SELECT 1 FROM sessions WHERE sessionID = $1 AND userID = $2 AND expiresAt > NOW())"
+
+	var active bool
+	err := m.DB.QueryRow(context.Background(), query, sessionID, userID).Scan(&active)
+	if err != nil {
+		return false, err
+	}
+
+	return active, nil
+}
+
+// FetchSessions returns the user's active sessions, most recently used first.
+func (m *SessionsModel) FetchSessions(userID string) ([]SessionDTO, error) {
+	query := "SELECT sessionID, ipAddress, platform, os, expiresAt, updatedAt FROM sessions WHERE userID = $1 AND expiresAt > NOW() ORDER BY updatedAt DESC"
+
+	rows, err := m.DB.Query(context.Background(), query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []SessionDTO{}
+	for rows.Next() {
+		var session SessionDTO
+		err = rows.Scan(&session.SessionID, &session.IpAddress, &session.Platform, &session.OS, &session.ExpiresAt, &session.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// DeleteUserSession deletes the session only if it belongs to the user.
+func (m *SessionsModel) DeleteUserSession(userID, sessionID string) error {
+	query := "DELETE FROM sessions WHERE sessionID = $1 AND userID = $2"
+
+	res, err := m.DB.Exec(context.Background(), query, sessionID, userID)
 	if err != nil {
 		return err
 	}
 
+	if res.RowsAffected() < 1 {
+		return ErrNoSessionsFound
+	}
+
 	return nil
 }
+
+// DeleteOtherSessions deletes every session of the user except keepSessionID and returns the IDs of the deleted ones.
+func (m *SessionsModel) DeleteOtherSessions(userID, keepSessionID string) ([]string, error) {
+	query := "DELETE FROM sessions WHERE userID = $1 AND sessionID <> $2 RETURNING sessionID"
+
+	rows, err := m.DB.Query(context.Background(), query, userID, keepSessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
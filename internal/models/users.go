@@ -6,10 +6,10 @@ import (
 	"time"
 
 	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/password"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
@@ -31,6 +31,7 @@ type UserDTO struct {
 	Username          string     `json:"username"`
 	DisplayName       NullString `json:"displayName"`
 	Email             string     `json:"email"`
+	Verified          bool       `json:"verified"`
 	JoinedAt          time.Time  `json:"joinedAt"`
 	UpdatedAt         time.Time  `json:"updatedAt"`
 	CustomStatus      NullString `json:"customStatus"`
@@ -52,17 +53,23 @@ type UserModel struct {
 	DB *pgxpool.Pool
 }
 
-func (m *UserModel) InsertUser(username, email, password string) (string, error) {
+func (m *UserModel) InsertUser(username, email, plainPassword string) (string, error) {
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	hashedPassword, err := password.Hash(plainPassword)
 	if err != nil {
 		return "", err
 	}
 
+	return insertUser(m.DB, username, email, hashedPassword, true)
+}
+
+// insertUser creates the user and returns their ID, or ErrDuplicateEmail or ErrDuplicateUsername if either is taken.
+// passwordSet is false for accounts created with a random password the user doesn't know.
+func insertUser(q queryer, username, email, hashedPassword string, passwordSet bool) (string, error) {
 	userID := internal.GenerateID()
-	query := "INSERT INTO users (userID, username, email, password) VALUES ($1, $2, $3, $4)"
+	query := "INSERT INTO users (userID, username, email, password, passwordSet) VALUES ($1, $2, $3, $4, $5)"
 
-	_, err = m.DB.Exec(context.Background(), query, userID, username, email, hashedPassword)
+	_, err := q.Exec(context.Background(), query, userID, username, email, hashedPassword, passwordSet)
 	if err != nil {
 		var pgError *pgconn.PgError
 		if errors.As(err, &pgError) {
@@ -83,7 +90,9 @@ func (m *UserModel) InsertUser(username, email, password string) (string, error)
 	return userID, nil
 }
 
-func (m *UserModel) Authenticate(email, password string) (string, error) {
+// Authenticate checks the user's email and password and returns their ID. If the email belongs to an account
+// but the password is wrong, the account's ID is returned along with ErrInvalidCredentials.
+func (m *UserModel) Authenticate(email, plainPassword string) (string, error) {
 	query := "SELECT userID, password FROM users WHERE email = $1"
 
 	var id string
@@ -99,12 +108,22 @@ func (m *UserModel) Authenticate(email, password string) (string, error) {
 		return "", err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	match, rehash, err := password.Verify(plainPassword, hashedPassword)
 	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return "", ErrInvalidCredentials
-		} else {
-			return "", err
+		return "", err
+	}
+
+	if !match {
+		return id, ErrInvalidCredentials
+	}
+
+	// Upgrade hashes made with an old algorithm or weaker parameters while the plain password is at hand.
+	// It's best effort, the old hash keeps working and the upgrade is tried again on the next login
+	if rehash {
+		newHashedPassword, err := password.Hash(plainPassword)
+		if err == nil {
+			query = "UPDATE users SET password = $1 WHERE userID = $2 AND password = $3"
+			m.DB.Exec(context.Background(), query, newHashedPassword, id, hashedPassword)
 		}
 	}
 
@@ -112,11 +131,27 @@ func (m *UserModel) Authenticate(email, password string) (string, error) {
 }
 
 func (m *UserModel) FetchUser(userID string) (UserDTO, error) {
-	query := "SELECT userID, username, email, joinedAt, customStatus, profilePictureURL, updatedAt, displayName, bio FROM users WHERE userID = $1"
+	query := "SELECT userID, username, email, verified, joinedAt, customStatus, profilePictureURL, updatedAt, displayName, bio FROM users WHERE userID = $1"
 
 	user := UserDTO{}
 	row := m.DB.QueryRow(context.Background(), query, userID)
-	err := row.Scan(&user.UserID, &user.Username, &user.Email, &user.JoinedAt, &user.CustomStatus, &user.ProfilePictureURL, &user.UpdatedAt, &user.DisplayName, &user.Bio)
+	err := row.Scan(&user.UserID, &user.Username, &user.Email, &user.Verified, &user.JoinedAt, &user.CustomStatus, &user.ProfilePictureURL, &user.UpdatedAt, &user.DisplayName, &user.Bio)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return user, ErrUserNotFound
+		}
+		return user, err
+	}
+
+	return user, nil
+}
+
+func (m *UserModel) FetchUserByEmail(email string) (UserDTO, error) {
+	query := "SELECT userID, username, email, verified, joinedAt, customStatus, profilePictureURL, updatedAt, displayName, bio FROM users WHERE email = $1"
+
+	user := UserDTO{}
+	row := m.DB.QueryRow(context.Background(), query, email)
+	err := row.Scan(&user.UserID, &user.Username, &user.Email, &user.Verified, &user.JoinedAt, &user.CustomStatus, &user.ProfilePictureURL, &user.UpdatedAt, &user.DisplayName, &user.Bio)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return user, ErrUserNotFound
@@ -157,12 +192,12 @@ func (m *UserModel) FetchUsersByUsername(username string) ([]PublicUserDTO, erro
 }
 
 func (m *UserModel) UpdateProfileInfo(userID, displayName, bio string) (UserDTO, error) {
-	query := "UPDATE users SET displayName = $1, bio = $2, updatedAt = NOW() WHERE userID = $4 RETURNING userID, username, email, joinedAt, customStatus, profilePictureURL, updatedAt, displayName, bio"
+	query := "UPDATE users SET displayName = $1, bio = $2, updatedAt = NOW() WHERE userID = $4 RETURNING userID, username, email, verified, joinedAt, customStatus, profilePictureURL, updatedAt, displayName, bio"
 
 	row := m.DB.QueryRow(context.Background(), query, displayName, bio, userID)
 
 	user := UserDTO{}
-	err := row.Scan(&user.UserID, &user.Username, &user.Email, &user.JoinedAt, &user.CustomStatus, &user.ProfilePictureURL, &user.UpdatedAt, &user.DisplayName, &user.Bio)
+	err := row.Scan(&user.UserID, &user.Username, &user.Email, &user.Verified, &user.JoinedAt, &user.CustomStatus, &user.ProfilePictureURL, &user.UpdatedAt, &user.DisplayName, &user.Bio)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return user, ErrUserNotFound
@@ -174,31 +209,41 @@ func (m *UserModel) UpdateProfileInfo(userID, displayName, bio string) (UserDTO,
 	return user, nil
 }
 
-func (m *UserModel) UpdatePassword(userID, oldPassword, newPassword string) error {
+// VerifyPassword checks the password of a logged in user, returning ErrInvalidCredentials if it's wrong.
+func (m *UserModel) VerifyPassword(userID, plainPassword string) error {
 	query := "SELECT password FROM users WHERE userID = $1"
 
-	var hashedOldPassword string
+	var hashedPassword string
 	row := m.DB.QueryRow(context.Background(), query, userID)
-	err := row.Scan(&hashedOldPassword)
+	err := row.Scan(&hashedPassword)
 	if err != nil {
 		return err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(hashedOldPassword), []byte(oldPassword))
+	match, _, err := password.Verify(plainPassword, hashedPassword)
 	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return ErrInvalidCredentials
-		}
+		return err
+	}
+
+	if !match {
+		return ErrInvalidCredentials
+	}
 
+	return nil
+}
+
+func (m *UserModel) UpdatePassword(userID, oldPassword, newPassword string) error {
+	err := m.VerifyPassword(userID, oldPassword)
+	if err != nil {
 		return err
 	}
 
-	newHashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), 12)
+	newHashedPassword, err := password.Hash(newPassword)
 	if err != nil {
 		return err
 	}
 
-	query = "UPDATE users SET password = $1 WHERE userID = $2"
+	query := "UPDATE users SET password = $1, passwordSet = TRUE WHERE userID = $2"
 	_, err = m.DB.Exec(context.Background(), query, newHashedPassword, userID)
 	if err != nil {
 		return err
@@ -206,3 +251,59 @@ func (m *UserModel) UpdatePassword(userID, oldPassword, newPassword string) erro
 
 	return nil
 }
+
+func (m *UserModel) IsVerified(userID string) (bool, error) {
+	query := "SELECT verified FROM users WHERE userID = $1"
+
+	var verified bool
+	err := m.DB.QueryRow(context.Background(), query, userID).Scan(&verified)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, ErrUserNotFound
+		}
+
+		return false, err
+	}
+
+	return verified, nil
+}
+
+// MarkVerified marks the user's email as verified, used when a trusted provider already verified it.
+func (m *UserModel) MarkVerified(userID string) error {
+	query := "UPDATE users SET verified = TRUE, updatedAt = NOW() WHERE userID = $1"
+
+	_, err := m.DB.Exec(context.Background(), query, userID)
+	return err
+}
+
+// FetchPresence returns the last status the user picked, empty if they never picked one.
+func (m *UserModel) FetchPresence(userID string) (string, error) {
+	query := "SELECT presence FROM users WHERE userID = $1"
+
+	var presence NullString
+	err := m.DB.QueryRow(context.Background(), query, userID).Scan(&presence)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrUserNotFound
+		}
+
+		return "", err
+	}
+
+	return string(presence), nil
+}
+
+func (m *UserModel) UpdatePresence(userID, presence string) error {
+	query := "UPDATE users SET presence = $1 WHERE userID = $2"
+
+	res, err := m.DB.Exec(context.Background(), query, presence, userID)
+	if err != nil {
+		return err
+	}
+
+	if res.RowsAffected() < 1 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+// Package oidc signs users in with external OpenID Connect providers using the authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var ErrUnknownProvider = errors.New("oidc: unknown provider")
+var ErrNonceMismatch = errors.New("oidc: the ID token nonce doesn't match the authorization request")
+var ErrMissingIDToken = errors.New("oidc: the token response has no ID token")
+
+// Timeout for every request made to a provider
+var requestTimeout = 10 * time.Second
+
+type ProviderConfig struct {
+	Name         string
+	Issuer       string // Used for discovery, can point to a local mock server during development
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Claims are the ID token claims used to identify the user.
+type Claims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+type provider struct {
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// Providers holds the configured providers. Discovery happens the first time a provider is used
+// so the API can start while a provider is unreachable.
+type Providers struct {
+	mu         sync.Mutex
+	configs    map[string]ProviderConfig
+	discovered map[string]*provider
+	client     *http.Client
+}
+
+func NewProviders(configs ...ProviderConfig) *Providers {
+	p := &Providers{
+		configs:    make(map[string]ProviderConfig),
+		discovered: make(map[string]*provider),
+		client:     &http.Client{Timeout: requestTimeout},
+	}
+
+	for _, config := range configs {
+		p.configs[config.Name] = config
+	}
+
+	return p
+}
+
+// LoadProviders reads the providers listed in OIDC_PROVIDERS from the environment.
+// Every provider needs OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID and OIDC_<NAME>_CLIENT_SECRET.
+// The redirect URL defaults to the web client's /oauth/callback/<name> page.
+func LoadProviders(appURL string) (*Providers, error) {
+	configs := []ProviderConfig{}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		config := ProviderConfig{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+		}
+
+		if config.Issuer == "" || config.ClientID == "" {
+			return nil, fmt.Errorf("oidc: provider %s needs an issuer and a client ID", name)
+		}
+
+		if config.RedirectURL == "" {
+			config.RedirectURL = appURL + "/oauth/callback/" + name
+		}
+
+		configs = append(configs, config)
+	}
+
+	return NewProviders(configs...), nil
+}
+
+// Names returns the configured providers in alphabetical order.
+func (p *Providers) Names() []string {
+	names := make([]string, 0, len(p.configs))
+	for name := range p.configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (p *Providers) Has(name string) bool {
+	_, ok := p.configs[name]
+	return ok
+}
+
+func (p *Providers) provider(ctx context.Context, name string) (*provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if discovered, ok := p.discovered[name]; ok {
+		return discovered, nil
+	}
+
+	config, ok := p.configs[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	oidcProvider, err := oidc.NewProvider(oidc.ClientContext(ctx, p.client), config.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	discovered := &provider{
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Endpoint:     oidcProvider.Endpoint(),
+			Scopes:       config.Scopes,
+		},
+		// Keys are fetched from the provider's JWKS and refreshed when an unknown key ID shows up
+		verifier: oidcProvider.VerifierContext(oidc.ClientContext(context.Background(), p.client), &oidc.Config{ClientID: config.ClientID}),
+	}
+	p.discovered[name] = discovered
+
+	return discovered, nil
+}
+
+// AuthCodeURL returns the URL the user has to be sent to in order to sign in with the provider.
+// state, nonce and the PKCE verifier must be stored until the user comes back.
+func (p *Providers) AuthCodeURL(ctx context.Context, name, state, nonce, verifier string) (string, error) {
+	provider, err := p.provider(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	return provider.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// Exchange trades the authorization code for tokens and returns the claims of the verified ID token.
+func (p *Providers) Exchange(ctx context.Context, name, code, nonce, verifier string) (Claims, error) {
+	provider, err := p.provider(ctx, name)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.client)
+
+	token, err := provider.oauth2.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return Claims{}, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return Claims{}, ErrMissingIDToken
+	}
+
+	idToken, err := provider.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		return Claims{}, ErrNonceMismatch
+	}
+
+	var claims Claims
+	err = idToken.Claims(&claims)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	return claims, nil
+}
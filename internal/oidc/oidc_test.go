@@ -0,0 +1,222 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/oidc/oidctest"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+var testUser = oidctest.User{
+	Subject:           "mock-subject",
+	Email:             "ada@example.com",
+	EmailVerified:     true,
+	PreferredUsername: "ada",
+}
+
+func newMockProvider(t *testing.T) (*Providers, *oidctest.Provider) {
+	t.Helper()
+
+	mock, err := oidctest.NewProvider("iris", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mock.Close)
+	mock.SetUser(testUser)
+
+	providers := NewProviders(ProviderConfig{
+		Name:         "mock",
+		Issuer:       mock.Issuer(),
+		ClientID:     "iris",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:5173/oauth/callback/mock",
+		Scopes:       []string{"openid", "email", "profile"},
+	})
+
+	return providers, mock
+}
+
+// authorize signs in with the mock provider and returns the code it redirected back with.
+func authorize(t *testing.T, providers *Providers, mock *oidctest.Provider, state, nonce, verifier string) string {
+	t.Helper()
+
+	authorizationURL, err := providers.AuthCodeURL(context.Background(), "mock", state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, returnedState, err := mock.Authorize(authorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if returnedState != state {
+		t.Fatalf("provider returned state %q, want %q", returnedState, state)
+	}
+
+	return code
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	providers, _ := newMockProvider(t)
+
+	authorizationURL, err := providers.AuthCodeURL(context.Background(), "mock", "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := url.Parse(authorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := parsed.Query()
+	want := map[string]string{
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"client_id":             "iris",
+		"code_challenge":        oauth2.S256ChallengeFromVerifier("verifier"),
+		"code_challenge_method": "S256",
+	}
+	for param, value := range want {
+		if query.Get(param) != value {
+			t.Errorf("%s = %q, want %q", param, query.Get(param), value)
+		}
+	}
+}
+
+func TestUnknownProvider(t *testing.T) {
+	providers, _ := newMockProvider(t)
+
+	_, err := providers.AuthCodeURL(context.Background(), "other", "state", "nonce", "verifier")
+	if !errors.Is(err, ErrUnknownProvider) {
+		t.Errorf("AuthCodeURL error = %v, want ErrUnknownProvider", err)
+	}
+
+	_, err = providers.Exchange(context.Background(), "other", "code", "nonce", "verifier")
+	if !errors.Is(err, ErrUnknownProvider) {
+		t.Errorf("Exchange error = %v, want ErrUnknownProvider", err)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	providers, mock := newMockProvider(t)
+	verifier := oauth2.GenerateVerifier()
+
+	code := authorize(t, providers, mock, "state", "nonce", verifier)
+
+	claims, err := providers.Exchange(context.Background(), "mock", code, "nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Claims{
+		Subject:           testUser.Subject,
+		Email:             testUser.Email,
+		EmailVerified:     testUser.EmailVerified,
+		PreferredUsername: testUser.PreferredUsername,
+	}
+	if claims != want {
+		t.Errorf("claims = %+v, want %+v", claims, want)
+	}
+}
+
+func TestExchangeRejectsReusedCode(t *testing.T) {
+	providers, mock := newMockProvider(t)
+	verifier := oauth2.GenerateVerifier()
+
+	code := authorize(t, providers, mock, "state", "nonce", verifier)
+
+	_, err := providers.Exchange(context.Background(), "mock", code, "nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = providers.Exchange(context.Background(), "mock", code, "nonce", verifier)
+	if err == nil {
+		t.Error("the same code was exchanged twice")
+	}
+}
+
+func TestExchangeRejectsNonceMismatch(t *testing.T) {
+	providers, mock := newMockProvider(t)
+	verifier := oauth2.GenerateVerifier()
+
+	code := authorize(t, providers, mock, "state", "nonce", verifier)
+
+	_, err := providers.Exchange(context.Background(), "mock", code, "another nonce", verifier)
+	if !errors.Is(err, ErrNonceMismatch) {
+		t.Errorf("error = %v, want ErrNonceMismatch", err)
+	}
+}
+
+func TestExchangeRejectsWrongCodeVerifier(t *testing.T) {
+	providers, mock := newMockProvider(t)
+
+	code := authorize(t, providers, mock, "state", "nonce", oauth2.GenerateVerifier())
+
+	_, err := providers.Exchange(context.Background(), "mock", code, "nonce", oauth2.GenerateVerifier())
+
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) || retrieveErr.ErrorCode != "invalid_grant" {
+		t.Errorf("error = %v, want an invalid_grant error", err)
+	}
+}
+
+func TestExchangeRejectsInvalidIDToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(mock *oidctest.Provider) error
+	}{
+		{
+			name: "foreign signing key",
+			setup: func(mock *oidctest.Provider) error {
+				return mock.SignWithForeignKey()
+			},
+		},
+		{
+			name: "other audience",
+			setup: func(mock *oidctest.Provider) error {
+				mock.Tamper = func(claims jwt.MapClaims) { claims["aud"] = "another-client" }
+				return nil
+			},
+		},
+		{
+			name: "other issuer",
+			setup: func(mock *oidctest.Provider) error {
+				mock.Tamper = func(claims jwt.MapClaims) { claims["iss"] = "https://issuer.example.com" }
+				return nil
+			},
+		},
+		{
+			name: "expired",
+			setup: func(mock *oidctest.Provider) error {
+				mock.Tamper = func(claims jwt.MapClaims) { claims["exp"] = claims["iat"].(int64) - 60 }
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			providers, mock := newMockProvider(t)
+			verifier := oauth2.GenerateVerifier()
+
+			err := test.setup(mock)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			code := authorize(t, providers, mock, "state", "nonce", verifier)
+
+			_, err = providers.Exchange(context.Background(), "mock", code, "nonce", verifier)
+			if err == nil {
+				t.Error("the ID token was accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const argon2idPrefix = "$argon2id$"
+
+// Argon2id hashes passwords with Argon2id, encoded in the PHC string format:
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>
+type Argon2id struct {
+	Memory      uint32 // In KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+type argon2idHash struct {
+	params Argon2id
+	salt   []byte
+	key    []byte
+}
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		a.Memory,
+		a.Iterations,
+		a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify hashes the password with the parameters stored in the hash, not the receiver's.
+func (a Argon2id) Verify(password, encoded string) (bool, error) {
+	hash, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(password), hash.salt, hash.params.Iterations, hash.params.Memory, hash.params.Parallelism, hash.params.KeyLength)
+
+	return subtle.ConstantTimeCompare(key, hash.key) == 1, nil
+}
+
+func (a Argon2id) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+func (a Argon2id) NeedsRehash(encoded string) bool {
+	hash, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+
+	return hash.params.Memory < a.Memory ||
+		hash.params.Iterations < a.Iterations ||
+		hash.params.Parallelism < a.Parallelism ||
+		hash.params.SaltLength < a.SaltLength ||
+		hash.params.KeyLength < a.KeyLength
+}
+
+func decodeArgon2id(encoded string) (argon2idHash, error) {
+	var hash argon2idHash
+
+	// "", "argon2id", version, parameters, salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return hash, ErrMalformedHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return hash, ErrMalformedHash
+	}
+
+	if version != argon2.Version {
+		return hash, ErrUnknownAlgorithm
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &hash.params.Memory, &hash.params.Iterations, &hash.params.Parallelism)
+	if err != nil || hash.params.Iterations == 0 || hash.params.Parallelism == 0 {
+		return hash, ErrMalformedHash
+	}
+
+	hash.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return hash, ErrMalformedHash
+	}
+
+	hash.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(hash.key) == 0 {
+		return hash, ErrMalformedHash
+	}
+
+	hash.params.SaltLength = uint32(len(hash.salt))
+	hash.params.KeyLength = uint32(len(hash.key))
+
+	return hash, nil
+}
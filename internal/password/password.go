@@ -0,0 +1,65 @@
+// Package password hashes user passwords. Stored hashes carry their algorithm and parameters,
+// so hashes made with older settings keep working and can be upgraded when the user logs in.
+package password
+
+import (
+	"errors"
+)
+
+var ErrUnknownAlgorithm = errors.New("password: the hash was made with an unsupported algorithm")
+var ErrMalformedHash = errors.New("password: the hash is malformed")
+
+// Hasher is a password hashing algorithm.
+type Hasher interface {
+	// Hash returns the encoded hash of the password, including the algorithm and its parameters.
+	Hash(password string) (string, error)
+
+	// Verify reports whether the password matches a hash produced by this algorithm.
+	Verify(password, encoded string) (bool, error)
+
+	// Recognizes reports whether the hash was produced by this algorithm, with any parameters.
+	Recognizes(encoded string) bool
+
+	// NeedsRehash reports whether the hash was made with weaker parameters than the hasher's.
+	NeedsRehash(encoded string) bool
+}
+
+// Default hashes every new password.
+var Default Hasher = Argon2id{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Hashes made by these are still accepted but replaced with the default on the next login.
+var legacy = []Hasher{
+	Bcrypt{Cost: 12},
+}
+
+// Hash hashes the password with the default hasher.
+func Hash(password string) (string, error) {
+	return Default.Hash(password)
+}
+
+// Verify checks the password against a hash made by any supported algorithm.
+// rehash is true when the password matched but the hash should be replaced with a new one from Hash.
+func Verify(password, encoded string) (match, rehash bool, err error) {
+	hashers := append([]Hasher{Default}, legacy...)
+
+	for _, hasher := range hashers {
+		if !hasher.Recognizes(encoded) {
+			continue
+		}
+
+		match, err = hasher.Verify(password, encoded)
+		if err != nil || !match {
+			return false, false, err
+		}
+
+		return true, hasher != Default || Default.NeedsRehash(encoded), nil
+	}
+
+	return false, false, ErrUnknownAlgorithm
+}
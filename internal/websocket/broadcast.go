@@ -1,10 +1,23 @@
 package websocket
 
-import "github.com/gofiber/fiber/v2/log"
+import (
+	"context"
+	"errors"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+const (
+	EventMessageCreate = "MESSAGE_CREATE"
+	EventMessageUpdate = "MESSAGE_UPDATE"
+	EventMessageDelete = "MESSAGE_DELETE"
+)
 
 type WebsocketMessage struct {
 	Type string `json:"type"`
 	Data any    `json:"data"`
+	Seq  uint64 `json:"seq,omitempty"` // Only set on dispatched events
 }
 
 type FriendStatus struct {
@@ -14,22 +27,88 @@ type FriendStatus struct {
 	ProfilePictureURL string `json:"profilePictureURL"` // The profile picture URL of the friend
 }
 
-func (ws *WebsocketServer) UpdateFriendStatus(recipientID string, status FriendStatus) error {
-	conn, ok := ws.Connections[recipientID]
+type MessageDelete struct {
+	MessageID string `json:"messageID"`
+	ChannelID string `json:"channelID"`
+}
+
+func (ws *WebsocketServer) send(recipientID string, message WebsocketMessage) error {
+	return ws.publish(Event{
+		UserIDs: []string{recipientID},
+		Message: &message,
+	})
+}
+
+func (ws *WebsocketServer) publish(event Event) error {
+	err := ws.Events.Publish(context.Background(), event)
+	if err != nil {
+		log.Errorf("Failed to publish gateway event: %v", err)
+	}
+
+	return err
+}
+
+// deliver hands an event coming from the event bus to the connections on this instance.
+func (ws *WebsocketServer) deliver(event Event) {
+	if event.Message != nil {
+		for _, userID := range event.UserIDs {
+			err := ws.Connections.Send(userID, *event.Message)
+			if err != nil && !errors.Is(err, ErrNoConnection) {
+				log.Errorf("Failed to send %s to user: %s", event.Message.Type, userID)
+			}
+		}
+	}
 
-	if !ok {
-		return ErrNoConnection
+	// Sessions are closed because they were revoked, so tokens issued for them must stop working on every instance
+	if event.SessionID != "" {
+		ws.Verifier.Revocations.Revoke(event.SessionID)
+		ws.Connections.CloseSession(event.SessionID, event.CloseCode, "session ended")
 	}
 
-	err := conn.Conn.WriteJSON(WebsocketMessage{
+	if event.CloseUserID != "" {
+		ws.Verifier.Revocations.InvalidateUser(event.CloseUserID)
+		ws.Connections.CloseUserSessions(event.CloseUserID, event.CloseCode, "session ended")
+	}
+}
+
+func (ws *WebsocketServer) UpdateFriendStatus(recipientID string, status FriendStatus) error {
+	return ws.send(recipientID, WebsocketMessage{
 		Type: "FRIEND_STATUS",
 		Data: status,
 	})
+}
+
+func (ws *WebsocketServer) SendChannelCreate(recipientID string, channel models.Channel) error {
+	return ws.send(recipientID, WebsocketMessage{
+		Type: "CHANNEL_CREATE",
+		Data: channel,
+	})
+}
+
+// Dispatch sends an event to every user in recipientIDs that is currently connected.
+func (ws *WebsocketServer) Dispatch(recipientIDs []string, eventType string, data any) {
+	if len(recipientIDs) == 0 {
+		return
+	}
 
+	ws.publish(Event{
+		UserIDs: recipientIDs,
+		Message: &WebsocketMessage{
+			Type: eventType,
+			Data: data,
+		},
+	})
+}
+
+// DispatchToChannel sends an event to every connected member of the channel.
+func (ws *WebsocketServer) DispatchToChannel(channelID, eventType string, data any) error {
+	memberIDs, err := ws.Channels.FetchMemberIDs(channelID)
 	if err != nil {
-		log.Error("Failed to send friend status update to user: ", recipientID)
+		log.Errorf("Failed to fetch members of channel %s for %s: %v", channelID, eventType, err)
 		return err
 	}
 
+	ws.Dispatch(memberIDs, eventType, data)
+
 	return nil
 }
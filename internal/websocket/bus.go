@@ -0,0 +1,54 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+)
+
+// Event is something the gateway has to deliver to connected clients. Events go through an EventBus
+// so they reach clients connected to any instance of the API, not just the one that produced them.
+type Event struct {
+	UserIDs     []string          `json:"userIDs,omitempty"`     // Users whose sessions should receive Message
+	Message     *WebsocketMessage `json:"message,omitempty"`     // Dispatched to every session of UserIDs
+	SessionID   string            `json:"sessionID,omitempty"`   // Session whose connection should be closed
+	CloseUserID string            `json:"closeUserID,omitempty"` // User whose every session should be closed
+	CloseCode   int               `json:"closeCode,omitempty"`   // Close code sent when closing SessionID or CloseUserID
+}
+
+// EventBus fans events out to every instance of the API.
+type EventBus interface {
+	// Publish sends the event to every subscriber, including the ones on this instance.
+	Publish(ctx context.Context, event Event) error
+
+	// Subscribe registers a handler that's called for every published event.
+	Subscribe(handler func(Event))
+}
+
+// InMemoryBus delivers events only to subscribers in the same process.
+// It's meant for single node deployments and tests.
+type InMemoryBus struct {
+	mu       sync.RWMutex
+	handlers []func(Event)
+}
+
+func NewInMemoryBus() *InMemoryBus {
+	return &InMemoryBus{}
+}
+
+func (b *InMemoryBus) Publish(ctx context.Context, event Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, handler := range b.handlers {
+		handler(event)
+	}
+
+	return nil
+}
+
+func (b *InMemoryBus) Subscribe(handler func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers = append(b.handlers, handler)
+}
@@ -2,4 +2,7 @@ package websocket
 
 import "errors"
 
-var ErrNoConnection = errors.New("websocket: no connection found for user")
+var ErrNoConnection = errors.New("websocket: no connection found for user or session")
+var ErrConnectionClosed = errors.New("websocket: the connection is closed")
+var ErrSlowConsumer = errors.New("websocket: the connection can't keep up with outgoing messages")
+var ErrCannotResume = errors.New("websocket: the session can't be resumed")
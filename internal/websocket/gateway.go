@@ -0,0 +1,224 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Gateway protocol
+//
+// Every frame is a JSON WebsocketMessage {"type": ..., "data": ...}.
+//
+//  1. After the upgrade the server sends HELLO with the heartbeat interval in milliseconds.
+//  2. The client must send IDENTIFY {"token": "<access token>"} as its first frame, within identifyTimeout.
+//  3. The server answers with READY {"sessionID", "userID", "status", "presences"} and starts dispatching events.
+//  4. The client sends HEARTBEAT every heartbeatInterval, the server answers with HEARTBEAT_ACK.
+//     Connections that go longer than heartbeatTimeout without a heartbeat are closed.
+//
+// Once identified the client can send PRESENCE_UPDATE {"status"} with one of online, idle, dnd or invisible,
+// and TYPING_START {"channelID"} while the user is typing in a channel.
+//
+// Every dispatched event carries a "seq" number. A client that drops can open a new connection and send
+// RESUME {"token", "seq"} with the last seq it received instead of IDENTIFY. The server replays every event
+// it missed followed by RESUMED, or answers INVALID_SESSION if the session can't be resumed, in which case
+// the client must IDENTIFY and fetch its state again.
+const (
+	OpHello          = "HELLO"
+	OpIdentify       = "IDENTIFY"
+	OpReady          = "READY"
+	OpResume         = "RESUME"
+	OpResumed        = "RESUMED"
+	OpInvalidSession = "INVALID_SESSION"
+	OpHeartbeat      = "HEARTBEAT"
+	OpHeartbeatAck   = "HEARTBEAT_ACK"
+)
+
+// Close codes sent by the gateway when it terminates a connection.
+const (
+	CloseUnknownError         = 4000 // Something unexpected happened, the client may reconnect
+	CloseUnknownOpcode        = 4001 // The client sent a message type the gateway doesn't handle
+	CloseDecodeError          = 4002 // The client sent a frame that isn't a valid message
+	CloseNotAuthenticated     = 4003 // The client sent a message before IDENTIFY
+	CloseAuthenticationFailed = 4004 // The token sent with IDENTIFY is invalid or expired
+	CloseAlreadyAuthenticated = 4005 // The client sent IDENTIFY more than once
+	CloseSessionEnded         = 4006 // The session was logged out or revoked, the client must log in again
+	CloseSessionTimedOut      = 4009 // The client didn't identify or heartbeat in time
+)
+
+var (
+	heartbeatInterval = 30 * time.Second
+	heartbeatTimeout  = heartbeatInterval + heartbeatInterval/2 // Grace period for network latency
+	identifyTimeout   = 10 * time.Second
+)
+
+type Hello struct {
+	HeartbeatInterval int64 `json:"heartbeatInterval"`
+}
+
+type Identify struct {
+	Token string `json:"token"`
+}
+
+type Ready struct {
+	SessionID string     `json:"sessionID"`
+	UserID    string     `json:"userID"`
+	Status    string     `json:"status"`    // The status the user last picked
+	Presences []Presence `json:"presences"` // Friends that are currently online
+}
+
+type Resume struct {
+	Token string `json:"token"`
+	Seq   uint64 `json:"seq"`
+}
+
+type Resumed struct {
+	Seq uint64 `json:"seq"`
+}
+
+type inboundMessage struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// closeError tells the read loop to close the connection with the given code.
+type closeError struct {
+	Code   int
+	Reason string
+}
+
+func (e *closeError) Error() string {
+	return fmt.Sprintf("websocket: closing connection with code %d: %s", e.Code, e.Reason)
+}
+
+var (
+	errUnknownOpcode        = &closeError{CloseUnknownOpcode, "unknown message type"}
+	errDecode               = &closeError{CloseDecodeError, "invalid payload"}
+	errNotAuthenticated     = &closeError{CloseNotAuthenticated, "not authenticated"}
+	errAuthenticationFailed = &closeError{CloseAuthenticationFailed, "authentication failed"}
+	errAlreadyAuthenticated = &closeError{CloseAlreadyAuthenticated, "already authenticated"}
+)
+
+func (ws *WebsocketServer) handleMessage(client *WebsocketClient, message inboundMessage) error {
+	if !client.identified && message.Type != OpIdentify && message.Type != OpResume {
+		return errNotAuthenticated
+	}
+
+	switch message.Type {
+	case OpIdentify:
+		return ws.identify(client, message.Data)
+	case OpResume:
+		return ws.resume(client, message.Data)
+	case OpPresenceUpdate:
+		return ws.updatePresence(client, message.Data)
+	case OpTypingStart:
+		return ws.startTyping(client, message.Data)
+	case OpHeartbeat:
+		client.Conn.SetReadDeadline(time.Now().Add(heartbeatTimeout))
+		return client.Send(WebsocketMessage{Type: OpHeartbeatAck})
+	default:
+		return errUnknownOpcode
+	}
+}
+
+func (ws *WebsocketServer) identify(client *WebsocketClient, data json.RawMessage) error {
+	if client.identified {
+		return errAlreadyAuthenticated
+	}
+
+	var identify Identify
+	err := json.Unmarshal(data, &identify)
+	if err != nil || identify.Token == "" {
+		return errDecode
+	}
+
+	userID, sessionID, err := ws.verifyAccessToken(identify.Token)
+	if err != nil {
+		return errAuthenticationFailed
+	}
+
+	client.SessionID = sessionID
+	client.UserID = userID
+	client.Status = ws.initialStatus(userID)
+	client.identified = true
+
+	ws.Connections.Register(client)
+	client.Conn.SetReadDeadline(time.Now().Add(heartbeatTimeout))
+
+	err = client.Send(WebsocketMessage{
+		Type: OpReady,
+		Data: Ready{
+			SessionID: sessionID,
+			UserID:    userID,
+			Status:    client.Status,
+			Presences: ws.friendPresences(userID),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	ws.storeConnectionStatus(client)
+
+	return nil
+}
+
+func (ws *WebsocketServer) resume(client *WebsocketClient, data json.RawMessage) error {
+	if client.identified {
+		return errAlreadyAuthenticated
+	}
+
+	var resume Resume
+	err := json.Unmarshal(data, &resume)
+	if err != nil || resume.Token == "" {
+		return errDecode
+	}
+
+	userID, sessionID, err := ws.verifyAccessToken(resume.Token)
+	if err != nil {
+		return errAuthenticationFailed
+	}
+
+	client.SessionID = sessionID
+	client.UserID = userID
+	client.Status = ws.initialStatus(userID)
+
+	err = ws.Connections.Resume(client, resume.Seq)
+	if err != nil {
+		if errors.Is(err, ErrCannotResume) {
+			// Keep the connection open so the client can IDENTIFY right away
+			client.SessionID = ""
+			client.UserID = ""
+			return client.Send(WebsocketMessage{Type: OpInvalidSession})
+		}
+
+		return err
+	}
+
+	client.identified = true
+	client.Conn.SetReadDeadline(time.Now().Add(heartbeatTimeout))
+
+	ws.storeConnectionStatus(client)
+
+	return nil
+}
+
+func (ws *WebsocketServer) verifyAccessToken(accessToken string) (string, string, error) {
+	claims, err := ws.Verifier.VerifyAccessToken(accessToken)
+	if err != nil {
+		return "", "", err
+	}
+
+	return claims.UserID, claims.SessionID, nil
+}
+
+// closeCodeFor returns the close code and reason that should be sent for an error returned by a message handler.
+func closeCodeFor(err error) (int, string) {
+	var ce *closeError
+	if errors.As(err, &ce) {
+		return ce.Code, ce.Reason
+	}
+
+	return CloseUnknownError, "unknown error"
+}
@@ -0,0 +1,318 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+const (
+	sendBufferSize   = 256              // Messages queued per connection before it's considered a slow consumer
+	replayBufferSize = 200              // Dispatched events kept per session so they can be replayed on RESUME
+	writeWait        = 10 * time.Second // Time allowed to write a single message to the connection
+)
+
+// How long a disconnected session is kept around so the client can resume it.
+var resumeWindow = 2 * time.Minute
+
+type WebsocketClient struct {
+	SessionID string
+	UserID    string
+	Status    string
+	Conn      *websocket.Conn
+
+	connectionID string // Identifies the connection in the presence shared between instances
+	identified   bool
+	send         chan WebsocketMessage
+	done         chan struct{}
+	writerDone   chan struct{}
+	closeOnce    sync.Once
+	closeCode    int
+	closeReason  string
+}
+
+// session holds the dispatch state of a gateway session. It outlives its connection for
+// resumeWindow so a client that drops can pick up where it left off.
+type session struct {
+	sessionID      string
+	userID         string
+	client         *WebsocketClient // nil while the session is disconnected
+	seq            uint64
+	replay         []WebsocketMessage
+	disconnectedAt time.Time
+}
+
+// Hub keeps track of every open connection. It's safe to use from multiple goroutines.
+// Connections are keyed by session so a user can be connected from several devices at once.
+type Hub struct {
+	mu       sync.Mutex
+	sessions map[string]*session            // sessionID -> session
+	users    map[string]map[string]*session // userID -> sessionID -> session
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		sessions: make(map[string]*session),
+		users:    make(map[string]map[string]*session),
+	}
+}
+
+// newClient creates a client for a connection that hasn't identified yet.
+func newClient(conn *websocket.Conn) *WebsocketClient {
+	return &WebsocketClient{
+		Status:       StatusOnline,
+		Conn:         conn,
+		connectionID: internal.GenerateID(),
+		send:         make(chan WebsocketMessage, sendBufferSize),
+		done:         make(chan struct{}),
+		writerDone:   make(chan struct{}),
+	}
+}
+
+// Send queues a message for the connection without blocking the caller.
+// If the queue is full the client is too slow to keep up and gets disconnected.
+func (c *WebsocketClient) Send(message WebsocketMessage) error {
+	select {
+	case <-c.done:
+		return ErrConnectionClosed
+	default:
+	}
+
+	select {
+	case c.send <- message:
+		return nil
+	default:
+		log.Warnf("Disconnecting slow websocket consumer for session: %s", c.SessionID)
+		c.Close(websocket.ClosePolicyViolation, "slow consumer")
+		return ErrSlowConsumer
+	}
+}
+
+// Close asks the writer goroutine to send a close frame with the given code and close the connection.
+// It never blocks and it's safe to call multiple times, only the first call has any effect.
+func (c *WebsocketClient) Close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeReason = reason
+		close(c.done)
+	})
+}
+
+// writeLoop is the only goroutine allowed to write to the connection.
+func (c *WebsocketClient) writeLoop() {
+	defer close(c.writerDone)
+
+	for {
+		select {
+		case <-c.done:
+			c.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeReason), time.Now().Add(writeWait))
+			c.Conn.Close()
+			return
+		case message := <-c.send:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+
+			err := c.Conn.WriteJSON(message)
+			if err != nil {
+				log.Errorf("Failed to write %s to websocket: %v", message.Type, err)
+				c.Close(websocket.CloseInternalServerErr, "write failed")
+			}
+		}
+	}
+}
+
+// dispatch stamps the message with the next sequence number, stores it for replay and
+// queues it on the connection if the session is connected. The hub lock must be held.
+func (s *session) dispatch(message WebsocketMessage) error {
+	s.seq++
+	message.Seq = s.seq
+
+	s.replay = append(s.replay, message)
+	if len(s.replay) > replayBufferSize {
+		s.replay = s.replay[len(s.replay)-replayBufferSize:]
+	}
+
+	if s.client == nil {
+		return ErrNoConnection
+	}
+
+	return s.client.Send(message)
+}
+
+// Register starts a new session for an identified client. Any previous connection or
+// resumable state for the same session is discarded.
+func (h *Hub) Register(client *WebsocketClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if previous, ok := h.sessions[client.SessionID]; ok {
+		h.remove(previous)
+		if previous.client != nil && previous.client != client {
+			previous.client.Close(websocket.CloseNormalClosure, "replaced by a new connection")
+		}
+	}
+
+	h.add(&session{
+		sessionID: client.SessionID,
+		userID:    client.UserID,
+		client:    client,
+	})
+}
+
+// Resume attaches the client to its existing session and queues every event dispatched after lastSeq,
+// followed by RESUMED. It returns ErrCannotResume if the session expired or the events are no longer buffered.
+func (h *Hub) Resume(client *WebsocketClient, lastSeq uint64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.sessions[client.SessionID]
+	if !ok || s.userID != client.UserID || lastSeq > s.seq {
+		return ErrCannotResume
+	}
+
+	// Every event after lastSeq must still be buffered, otherwise the client missed something for good
+	missed := s.seq - lastSeq
+	if missed > uint64(len(s.replay)) {
+		return ErrCannotResume
+	}
+
+	if s.client != nil && s.client != client {
+		s.client.Close(websocket.CloseNormalClosure, "replaced by a new connection")
+	}
+	s.client = client
+
+	for _, message := range s.replay[uint64(len(s.replay))-missed:] {
+		err := client.Send(message)
+		if err != nil {
+			return err
+		}
+	}
+
+	return client.Send(WebsocketMessage{
+		Type: OpResumed,
+		Data: Resumed{Seq: s.seq},
+	})
+}
+
+// Unregister detaches the client from its session. The session can still be resumed for resumeWindow.
+func (h *Hub) Unregister(client *WebsocketClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.sessions[client.SessionID]
+	if !ok || s.client != client {
+		return
+	}
+
+	s.client = nil
+	s.disconnectedAt = time.Now()
+
+	time.AfterFunc(resumeWindow, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		// Only drop the session if it's still the same one and nobody resumed it in the meantime
+		if h.sessions[s.sessionID] == s && s.client == nil && time.Since(s.disconnectedAt) >= resumeWindow {
+			h.remove(s)
+		}
+	})
+}
+
+// Send dispatches the message to every session the user has, connected or waiting to be resumed.
+func (h *Hub) Send(userID string, message WebsocketMessage) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sent := false
+	var sendErr error
+	for _, s := range h.users[userID] {
+		err := s.dispatch(message)
+		if err == nil {
+			sent = true
+		} else if err != ErrNoConnection {
+			sendErr = err
+		}
+	}
+
+	if sendErr != nil {
+		return sendErr
+	}
+
+	if !sent {
+		return ErrNoConnection
+	}
+
+	return nil
+}
+
+func (h *Hub) SendToSession(sessionID string, message WebsocketMessage) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.sessions[sessionID]
+	if !ok {
+		return ErrNoConnection
+	}
+
+	return s.dispatch(message)
+}
+
+// CloseSession disconnects the connection that belongs to the given session and discards its state
+// so it can't be resumed.
+func (h *Hub) CloseSession(sessionID string, code int, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.sessions[sessionID]
+	if !ok {
+		return
+	}
+
+	h.remove(s)
+	if s.client != nil {
+		s.client.Close(code, reason)
+	}
+}
+
+// CloseUserSessions disconnects every connection of the user and discards their sessions.
+func (h *Hub) CloseUserSessions(userID string, code int, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, s := range h.users[userID] {
+		h.remove(s)
+		if s.client != nil {
+			s.client.Close(code, reason)
+		}
+	}
+}
+
+func (h *Hub) add(s *session) {
+	h.sessions[s.sessionID] = s
+
+	userSessions, ok := h.users[s.userID]
+	if !ok {
+		userSessions = make(map[string]*session)
+		h.users[s.userID] = userSessions
+	}
+	userSessions[s.sessionID] = s
+}
+
+func (h *Hub) remove(s *session) {
+	delete(h.sessions, s.sessionID)
+
+	userSessions := h.users[s.userID]
+	delete(userSessions, s.sessionID)
+	if len(userSessions) == 0 {
+		delete(h.users, s.userID)
+	}
+}
+
+func (h *Hub) SetStatus(client *WebsocketClient, status string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	client.Status = status
+}
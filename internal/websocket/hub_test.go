@@ -0,0 +1,257 @@
+package websocket
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// testClient returns an identified client with no connection, its messages stay queued in send.
+func testClient(sessionID, userID string) *WebsocketClient {
+	client := newClient(nil)
+	client.SessionID = sessionID
+	client.UserID = userID
+	client.identified = true
+
+	return client
+}
+
+// received drains the messages queued for the client.
+func received(client *WebsocketClient) []WebsocketMessage {
+	var messages []WebsocketMessage
+	for {
+		select {
+		case message := <-client.send:
+			messages = append(messages, message)
+		default:
+			return messages
+		}
+	}
+}
+
+func isClosed(client *WebsocketClient) bool {
+	select {
+	case <-client.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// dispatchEvents sends count events to the user and fails the test if any can't be dispatched.
+func dispatchEvents(t *testing.T, hub *Hub, userID string, count int) {
+	t.Helper()
+
+	for range count {
+		err := hub.Send(userID, WebsocketMessage{Type: "EVENT"})
+		if err != nil && !errors.Is(err, ErrNoConnection) {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHubSendStampsSequence(t *testing.T) {
+	hub := NewHub()
+	client := testClient("session", "user")
+	hub.Register(client)
+
+	dispatchEvents(t, hub, "user", 3)
+
+	messages := received(client)
+	if len(messages) != 3 {
+		t.Fatalf("received %d messages, want 3", len(messages))
+	}
+
+	for i, message := range messages {
+		if message.Seq != uint64(i+1) {
+			t.Errorf("message %d has seq %d, want %d", i, message.Seq, i+1)
+		}
+	}
+}
+
+func TestHubSendWithoutConnection(t *testing.T) {
+	hub := NewHub()
+
+	err := hub.Send("user", WebsocketMessage{Type: "EVENT"})
+	if !errors.Is(err, ErrNoConnection) {
+		t.Fatalf("Send = %v, want ErrNoConnection", err)
+	}
+}
+
+func TestHubResumeReplaysMissedEvents(t *testing.T) {
+	hub := NewHub()
+	client := testClient("session", "user")
+	hub.Register(client)
+
+	dispatchEvents(t, hub, "user", 2)
+	received(client)
+
+	// Events dispatched while disconnected are buffered for the resumed connection
+	hub.Unregister(client)
+	dispatchEvents(t, hub, "user", 3)
+
+	resumed := testClient("session", "user")
+	err := hub.Resume(resumed, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := received(resumed)
+	if len(messages) != 4 {
+		t.Fatalf("received %d messages, want 3 events and RESUMED", len(messages))
+	}
+
+	for i, message := range messages[:3] {
+		if message.Seq != uint64(i+3) {
+			t.Errorf("replayed message %d has seq %d, want %d", i, message.Seq, i+3)
+		}
+	}
+
+	last := messages[3]
+	if last.Type != OpResumed || last.Data.(Resumed).Seq != 5 {
+		t.Fatalf("last message is %+v, want RESUMED at seq 5", last)
+	}
+
+	// New events keep the sequence of the session
+	dispatchEvents(t, hub, "user", 1)
+	messages = received(resumed)
+	if len(messages) != 1 || messages[0].Seq != 6 {
+		t.Fatalf("received %+v after resuming, want one event at seq 6", messages)
+	}
+}
+
+func TestHubResumeReplacesConnection(t *testing.T) {
+	hub := NewHub()
+	client := testClient("session", "user")
+	hub.Register(client)
+
+	resumed := testClient("session", "user")
+	err := hub.Resume(resumed, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !isClosed(client) {
+		t.Fatal("previous connection of the session wasn't closed")
+	}
+
+	// Unregistering the replaced connection must not detach the new one
+	hub.Unregister(client)
+	dispatchEvents(t, hub, "user", 1)
+	if len(received(resumed)) != 2 {
+		t.Fatal("resumed connection didn't receive the event")
+	}
+}
+
+func TestHubResumeFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		userID  string
+		lastSeq uint64
+	}{
+		{"another user", "other", 0},
+		{"seq ahead of the session", "user", replayBufferSize + 11},
+		{"events no longer buffered", "user", 9},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hub := NewHub()
+			client := testClient("session", "user")
+			hub.Register(client)
+
+			// More events than the replay buffer holds, the first 10 are lost
+			dispatchEvents(t, hub, "user", replayBufferSize+10)
+			hub.Unregister(client)
+
+			err := hub.Resume(testClient("session", test.userID), test.lastSeq)
+			if !errors.Is(err, ErrCannotResume) {
+				t.Fatalf("Resume = %v, want ErrCannotResume", err)
+			}
+		})
+	}
+}
+
+func TestHubResumeUnknownSession(t *testing.T) {
+	hub := NewHub()
+
+	err := hub.Resume(testClient("session", "user"), 0)
+	if !errors.Is(err, ErrCannotResume) {
+		t.Fatalf("Resume = %v, want ErrCannotResume", err)
+	}
+}
+
+func TestHubResumeAfterWindow(t *testing.T) {
+	window := resumeWindow
+	resumeWindow = 10 * time.Millisecond
+	t.Cleanup(func() { resumeWindow = window })
+
+	hub := NewHub()
+	client := testClient("session", "user")
+	hub.Register(client)
+	hub.Unregister(client)
+
+	time.Sleep(50 * time.Millisecond)
+
+	err := hub.Resume(testClient("session", "user"), 0)
+	if !errors.Is(err, ErrCannotResume) {
+		t.Fatalf("Resume = %v, want ErrCannotResume", err)
+	}
+}
+
+func TestHubRegisterDiscardsSession(t *testing.T) {
+	hub := NewHub()
+	client := testClient("session", "user")
+	hub.Register(client)
+	dispatchEvents(t, hub, "user", 3)
+	hub.Unregister(client)
+
+	// Identifying again starts over, the previous events can't be resumed
+	hub.Register(testClient("session", "user"))
+
+	err := hub.Resume(testClient("session", "user"), 1)
+	if !errors.Is(err, ErrCannotResume) {
+		t.Fatalf("Resume = %v, want ErrCannotResume", err)
+	}
+}
+
+func TestHubCloseSessionCannotResume(t *testing.T) {
+	hub := NewHub()
+	client := testClient("session", "user")
+	hub.Register(client)
+
+	hub.CloseSession("session", 1000, "logged out")
+	if !isClosed(client) {
+		t.Fatal("connection wasn't closed")
+	}
+
+	err := hub.Resume(testClient("session", "user"), 0)
+	if !errors.Is(err, ErrCannotResume) {
+		t.Fatalf("Resume = %v, want ErrCannotResume", err)
+	}
+}
+
+func TestClientSlowConsumer(t *testing.T) {
+	client := testClient("session", "user")
+
+	for range sendBufferSize {
+		err := client.Send(WebsocketMessage{Type: "EVENT"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := client.Send(WebsocketMessage{Type: "EVENT"})
+	if !errors.Is(err, ErrSlowConsumer) {
+		t.Fatalf("Send = %v, want ErrSlowConsumer", err)
+	}
+
+	if !isClosed(client) {
+		t.Fatal("slow consumer wasn't disconnected")
+	}
+
+	err = client.Send(WebsocketMessage{Type: "EVENT"})
+	if !errors.Is(err, ErrConnectionClosed) {
+		t.Fatalf("Send = %v, want ErrConnectionClosed", err)
+	}
+}
@@ -0,0 +1,190 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Postgres rejects NOTIFY payloads of 8000 bytes or more, bigger events are stored in a table
+// and only their ID is sent with the notification.
+const maxNotifyPayload = 7900
+
+// How long oversized events are kept in the gatewayEvents table for listeners to read them.
+var overflowRetention = 5 * time.Minute
+
+// PostgresBus fans events out between API instances using LISTEN/NOTIFY.
+// Events are delivered to local subscribers right away and the notification an instance receives
+// for its own events is ignored.
+type PostgresBus struct {
+	DB      *pgxpool.Pool
+	Channel string
+
+	instanceID string
+	mu         sync.RWMutex
+	handlers   []func(Event)
+}
+
+type notification struct {
+	Origin  string `json:"origin"`
+	Event   *Event `json:"event,omitempty"`
+	EventID string `json:"eventID,omitempty"` // Set instead of Event when the event was too large to send inline
+}
+
+func NewPostgresBus(db *pgxpool.Pool, channel string) *PostgresBus {
+	return &PostgresBus{
+		DB:         db,
+		Channel:    channel,
+		instanceID: internal.GenerateID(),
+	}
+}
+
+func (b *PostgresBus) Subscribe(handler func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers = append(b.handlers, handler)
+}
+
+func (b *PostgresBus) Publish(ctx context.Context, event Event) error {
+	b.deliver(event)
+
+	payload, err := json.Marshal(notification{Origin: b.instanceID, Event: &event})
+	if err != nil {
+		return err
+	}
+
+	if len(payload) > maxNotifyPayload {
+		payload, err = b.storeOverflow(ctx, event)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = b.DB.Exec(ctx, "SELECT pg_notify($1, $2)", b.Channel, string(payload))
+	return err
+}
+
+// Listen receives events published by other instances until the context is cancelled,
+// reconnecting with a backoff whenever the listening connection is lost.
+func (b *PostgresBus) Listen(ctx context.Context) error {
+	backoff := time.Second
+
+	for {
+		err := b.listen(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		log.Errorf("Event bus listener disconnected, retrying in %s: %v", backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+func (b *PostgresBus) listen(ctx context.Context) error {
+	poolConn, err := b.DB.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The connection stays in LISTEN mode, so take it out of the pool instead of releasing it
+	conn := poolConn.Hijack()
+	defer conn.Close(context.Background())
+
+	_, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{b.Channel}.Sanitize())
+	if err != nil {
+		return err
+	}
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var message notification
+		err = json.Unmarshal([]byte(n.Payload), &message)
+		if err != nil {
+			log.Errorf("Failed to decode event bus notification: %v", err)
+			continue
+		}
+
+		if message.Origin == b.instanceID {
+			continue
+		}
+
+		if message.Event == nil {
+			message.Event, err = b.loadOverflow(ctx, message.EventID)
+			if err != nil {
+				log.Errorf("Failed to load event %s from the event bus: %v", message.EventID, err)
+				continue
+			}
+		}
+
+		b.deliver(*message.Event)
+	}
+}
+
+func (b *PostgresBus) deliver(event Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, handler := range b.handlers {
+		handler(event)
+	}
+}
+
+func (b *PostgresBus) storeOverflow(ctx context.Context, event Event) ([]byte, error) {
+	eventPayload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	eventID := internal.GenerateID()
+
+	query := "INSERT INTO gatewayEvents (eventID, payload) VALUES ($1, $2)"
+	_, err = b.DB.Exec(ctx, query, eventID, eventPayload)
+	if err != nil {
+		return nil, err
+	}
+
+	// Clean up events every listener has had plenty of time to read
+	query = "DELETE FROM gatewayEvents WHERE createdAt < $1"
+	_, err = b.DB.Exec(ctx, query, time.Now().Add(-overflowRetention))
+	if err != nil {
+		log.Errorf("Failed to clean up old gateway events: %v", err)
+	}
+
+	return json.Marshal(notification{Origin: b.instanceID, EventID: eventID})
+}
+
+func (b *PostgresBus) loadOverflow(ctx context.Context, eventID string) (*Event, error) {
+	query := "SELECT payload FROM gatewayEvents WHERE eventID = $1"
+
+	var payload []byte
+	err := b.DB.QueryRow(ctx, query, eventID).Scan(&payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var event Event
+	err = json.Unmarshal(payload, &event)
+	if err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
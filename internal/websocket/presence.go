@@ -0,0 +1,246 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+const (
+	OpPresenceUpdate    = "PRESENCE_UPDATE"
+	EventPresenceUpdate = "PRESENCE_UPDATE"
+)
+
+const (
+	StatusOnline    = "online"
+	StatusIdle      = "idle"
+	StatusDND       = "dnd"
+	StatusInvisible = "invisible"
+	StatusOffline   = "offline"
+)
+
+// statusPriority decides which status wins when a user is connected from several devices.
+// Invisible always wins so a user hiding on one device isn't revealed by another, and idle
+// loses to everything because clients set it automatically after some inactivity.
+var statusPriority = map[string]int{
+	StatusIdle:      1,
+	StatusOnline:    2,
+	StatusDND:       3,
+	StatusInvisible: 4,
+}
+
+type Presence struct {
+	UserID string `json:"userID"`
+	Status string `json:"status"`
+}
+
+type PresenceUpdate struct {
+	Status string `json:"status"`
+}
+
+// visibleStatus is the status other users see.
+func visibleStatus(status string) string {
+	if status == StatusInvisible {
+		return StatusOffline
+	}
+
+	return status
+}
+
+// initialStatus returns the last status the user picked, so a reconnecting user keeps being DND or invisible.
+func (ws *WebsocketServer) initialStatus(userID string) string {
+	status, err := ws.Users.FetchPresence(userID)
+	if err != nil {
+		log.Errorf("Failed to fetch presence for user %s: %v", userID, err)
+		return StatusOnline
+	}
+
+	if _, ok := statusPriority[status]; !ok {
+		return StatusOnline
+	}
+
+	return status
+}
+
+func (ws *WebsocketServer) updatePresence(client *WebsocketClient, data json.RawMessage) error {
+	var update PresenceUpdate
+	err := json.Unmarshal(data, &update)
+	if err != nil {
+		return errDecode
+	}
+
+	if _, ok := statusPriority[update.Status]; !ok {
+		return errDecode
+	}
+
+	ws.Connections.SetStatus(client, update.Status)
+
+	// Idle is set automatically by clients so it's not worth remembering
+	if update.Status != StatusIdle {
+		err = ws.Users.UpdatePresence(client.UserID, update.Status)
+		if err != nil {
+			log.Errorf("Failed to save presence for user %s: %v", client.UserID, err)
+		}
+	}
+
+	ws.storeConnectionStatus(client)
+
+	return nil
+}
+
+// aggregateStatus returns the status that wins among the statuses of a user's connections.
+func aggregateStatus(statuses []string) string {
+	status := StatusOffline
+	for _, s := range statuses {
+		if statusPriority[s] > statusPriority[status] {
+			status = s
+		}
+	}
+
+	return status
+}
+
+// storeConnectionStatus shares the status of the client's connection with every instance
+// and publishes the user's presence if it changed.
+func (ws *WebsocketServer) storeConnectionStatus(client *WebsocketClient) {
+	change, err := ws.Presences.SetConnectionStatus(client.UserID, client.connectionID, ws.InstanceID, client.Status, aggregateStatus)
+	if err != nil {
+		log.Errorf("Failed to store presence of user %s: %v", client.UserID, err)
+		return
+	}
+
+	ws.publishPresence(client.UserID, change)
+}
+
+// removeConnectionStatus forgets the status of a closed connection and publishes the user's presence if it changed.
+func (ws *WebsocketServer) removeConnectionStatus(client *WebsocketClient) {
+	change, err := ws.Presences.RemoveConnection(client.UserID, client.connectionID, aggregateStatus)
+	if err != nil {
+		log.Errorf("Failed to remove presence of user %s: %v", client.UserID, err)
+		return
+	}
+
+	ws.publishPresence(client.UserID, change)
+}
+
+// RefreshPresences keeps the connections of this instance counting towards their users' presence and,
+// every interval until the context is cancelled, publishes the presence of users whose connections
+// expired because the instance they were connected to stopped. The interval must be shorter than models.PresenceTTL.
+func (ws *WebsocketServer) RefreshPresences(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := ws.Presences.RefreshInstance(ws.InstanceID)
+		if err != nil {
+			log.Errorf("Failed to refresh presences: %v", err)
+		}
+
+		userIDs, err := ws.Presences.RemoveExpiredConnections()
+		if err != nil {
+			log.Errorf("Failed to remove expired presences: %v", err)
+			continue
+		}
+
+		for _, userID := range userIDs {
+			change, err := ws.Presences.RecomputePresence(userID, aggregateStatus)
+			if err != nil {
+				log.Errorf("Failed to recompute presence of user %s: %v", userID, err)
+				continue
+			}
+
+			ws.publishPresence(userID, change)
+		}
+	}
+}
+
+// publishPresence sends the user's new status to their own sessions and to their friends if it changed.
+// Changes are aggregated across every instance, so each one is published once. Friends never see invisible users online.
+func (ws *WebsocketServer) publishPresence(userID string, change models.PresenceChange) {
+	if !change.Changed() {
+		return
+	}
+
+	status := change.Status
+	if status == "" {
+		status = StatusOffline
+	}
+
+	previous := change.Previous
+	if previous == "" {
+		previous = StatusOffline
+	}
+
+	// Keep the user's other devices in sync with the real status
+	ws.send(userID, WebsocketMessage{
+		Type: EventPresenceUpdate,
+		Data: Presence{UserID: userID, Status: status},
+	})
+
+	if visibleStatus(status) == visibleStatus(previous) {
+		return
+	}
+
+	friendIDs, err := ws.friendIDs(userID)
+	if err != nil {
+		log.Errorf("Failed to fetch friends of user %s for presence update: %v", userID, err)
+		return
+	}
+
+	ws.Dispatch(friendIDs, EventPresenceUpdate, Presence{
+		UserID: userID,
+		Status: visibleStatus(status),
+	})
+}
+
+// friendPresences returns the visible status of every friend of the user that isn't offline,
+// wherever they're connected.
+func (ws *WebsocketServer) friendPresences(userID string) []Presence {
+	presences := []Presence{}
+
+	friendIDs, err := ws.friendIDs(userID)
+	if err != nil {
+		log.Errorf("Failed to fetch friends of user %s for presences: %v", userID, err)
+		return presences
+	}
+
+	statuses, err := ws.Presences.FetchPresences(friendIDs)
+	if err != nil {
+		log.Errorf("Failed to fetch presences of the friends of user %s: %v", userID, err)
+		return presences
+	}
+
+	for _, friendID := range friendIDs {
+		status := visibleStatus(statuses[friendID])
+		if status != StatusOffline && status != "" {
+			presences = append(presences, Presence{UserID: friendID, Status: status})
+		}
+	}
+
+	return presences
+}
+
+func (ws *WebsocketServer) friendIDs(userID string) ([]string, error) {
+	friends, err := ws.Relationships.FetchFriends(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	friendIDs := make([]string, 0, len(friends))
+	for _, friend := range friends {
+		if friend.Status == "accepted" {
+			friendIDs = append(friendIDs, friend.UserID)
+		}
+	}
+
+	return friendIDs, nil
+}
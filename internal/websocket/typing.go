@@ -0,0 +1,165 @@
+package websocket
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+)
+
+const (
+	OpTypingStart    = "TYPING_START"
+	EventTypingStart = "TYPING_START"
+	EventTypingStop  = "TYPING_STOP"
+)
+
+var (
+	typingRateLimit = 5 * time.Second  // Minimum time between TYPING_START events from the same user in a channel
+	typingTimeout   = 10 * time.Second // Time after the last TYPING_START before the user stops being shown as typing
+)
+
+type TypingStartRequest struct {
+	ChannelID string `json:"channelID"`
+}
+
+type Typing struct {
+	ChannelID string `json:"channelID"`
+	UserID    string `json:"userID"`
+	Timestamp int64  `json:"timestamp,omitempty"` // Unix milliseconds, only set on TYPING_START
+}
+
+type typingState struct {
+	lastSent time.Time
+	timer    *time.Timer
+}
+
+// typingTracker remembers who is typing in which channel so events can be rate limited and expired.
+type typingTracker struct {
+	mu     sync.Mutex
+	states map[string]*typingState // channelID:userID -> state
+}
+
+func typingKey(channelID, userID string) string {
+	return channelID + ":" + userID
+}
+
+func (t *typingTracker) rateLimited(channelID, userID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.states[typingKey(channelID, userID)]
+	return ok && time.Since(state.lastSent) < typingRateLimit
+}
+
+// start marks the user as typing and calls onExpire if they don't type again within typingTimeout.
+func (t *typingTracker) start(channelID, userID string, onExpire func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.states == nil {
+		t.states = make(map[string]*typingState)
+	}
+
+	key := typingKey(channelID, userID)
+	state, ok := t.states[key]
+	if ok {
+		state.timer.Stop()
+	} else {
+		state = &typingState{}
+		t.states[key] = state
+	}
+
+	state.lastSent = time.Now()
+	state.timer = time.AfterFunc(typingTimeout, func() {
+		t.mu.Lock()
+		current, ok := t.states[key]
+		if !ok || current != state || time.Since(state.lastSent) < typingTimeout {
+			t.mu.Unlock()
+			return
+		}
+		delete(t.states, key)
+		t.mu.Unlock()
+
+		onExpire()
+	})
+}
+
+// stop forgets the typing state without notifying anyone, returns false if the user wasn't typing.
+func (t *typingTracker) stop(channelID, userID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := typingKey(channelID, userID)
+	state, ok := t.states[key]
+	if !ok {
+		return false
+	}
+
+	state.timer.Stop()
+	delete(t.states, key)
+
+	return true
+}
+
+func (ws *WebsocketServer) startTyping(client *WebsocketClient, data json.RawMessage) error {
+	var request TypingStartRequest
+	err := json.Unmarshal(data, &request)
+	if err != nil || request.ChannelID == "" {
+		return errDecode
+	}
+
+	if ws.typing.rateLimited(request.ChannelID, client.UserID) {
+		return nil
+	}
+
+	// Silently ignore channels the user can't post in, there's nothing useful to tell the client
+	_, err = ws.Channels.FetchMember(request.ChannelID, client.UserID)
+	if err != nil {
+		return nil
+	}
+
+	blocked, err := ws.Channels.IsBlocked(request.ChannelID, client.UserID)
+	if err != nil || blocked {
+		return nil
+	}
+
+	ws.typing.start(request.ChannelID, client.UserID, func() {
+		ws.dispatchTyping(request.ChannelID, client.UserID, EventTypingStop, Typing{
+			ChannelID: request.ChannelID,
+			UserID:    client.UserID,
+		})
+	})
+
+	ws.dispatchTyping(request.ChannelID, client.UserID, EventTypingStart, Typing{
+		ChannelID: request.ChannelID,
+		UserID:    client.UserID,
+		Timestamp: time.Now().UnixMilli(),
+	})
+
+	return nil
+}
+
+// StopTyping clears the typing state of a user, called when they send a message to the channel.
+// No TYPING_STOP is sent since clients already stop showing the indicator on MESSAGE_CREATE.
+func (ws *WebsocketServer) StopTyping(channelID, userID string) {
+	ws.typing.stop(channelID, userID)
+}
+
+// dispatchTyping sends a typing event to every member of the channel except the one typing.
+func (ws *WebsocketServer) dispatchTyping(channelID, userID, eventType string, typing Typing) {
+	memberIDs, err := ws.Channels.FetchMemberIDs(channelID)
+	if err != nil {
+		log.Errorf("Failed to fetch members of channel %s for %s: %v", channelID, eventType, err)
+		return
+	}
+
+	recipientIDs := make([]string, 0, len(memberIDs))
+	for _, memberID := range memberIDs {
+		if memberID != userID {
+			recipientIDs = append(recipientIDs, memberID)
+		}
+	}
+
+	ws.Dispatch(recipientIDs, eventType, typing)
+}
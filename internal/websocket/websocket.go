@@ -1,48 +1,38 @@
 package websocket
 
 import (
+	"encoding/json"
+	"errors"
 	"log"
-	"net/http"
+	"net"
+	"time"
 
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/auth"
+	"github.com/CDavidSV/Iris-Chat-App-Backend/internal/models"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-type WebsocketClient struct {
-	Status string
-	Conn   *websocket.Conn
-}
-
 type WebsocketServer struct {
-	Connections       map[string]WebsocketClient
-	AccessTokenSecret string
-	DB                *pgxpool.Pool
+	Connections   *Hub
+	Events        EventBus
+	DB            *pgxpool.Pool
+	Users         *models.UserModel
+	Relationships *models.RelationshipModel
+	Channels      *models.ChannelModel
+	Presences     *models.PresenceModel
+	Verifier      *auth.Verifier
+
+	// Identifies this instance's connections in the presence shared between instances
+	InstanceID string
+
+	typing typingTracker
 }
 
 func (ws *WebsocketServer) WebsocketUpgrade(c *fiber.Ctx) error {
 	if websocket.IsWebSocketUpgrade(c) {
-		// Validate access token
-		accessToken := c.Query("token")
-		if accessToken == "" {
-			return c.SendStatus(http.StatusUnauthorized)
-		}
-
-		claims := jwt.MapClaims{}
-		token, err := jwt.ParseWithClaims(accessToken, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(ws.AccessTokenSecret), nil
-		})
-		if err != nil {
-			return c.SendStatus(http.StatusUnauthorized)
-		}
-		if !token.Valid {
-			return c.SendStatus(http.StatusUnauthorized)
-		}
-
-		c.Locals("userID", claims["userID"])
-		c.Locals("sessionID", claims["sessionID"])
-
+		// Authentication happens with the IDENTIFY message once the connection is open
 		return c.Next()
 	}
 
@@ -51,27 +41,76 @@ func (ws *WebsocketServer) WebsocketUpgrade(c *fiber.Ctx) error {
 
 func (ws *WebsocketServer) NewWebsocket() func(*fiber.Ctx) error {
 	return websocket.New(func(c *websocket.Conn) {
-		if len(ws.Connections) == 0 {
-			ws.Connections = make(map[string]WebsocketClient)
-		}
+		client := newClient(c)
+		go client.writeLoop()
 
-		// Add the connection to the map
-		ws.Connections[c.Locals("userID").(string)] = WebsocketClient{
-			Status: "online",
-			Conn:   c,
-		}
+		client.Send(WebsocketMessage{
+			Type: OpHello,
+			Data: Hello{HeartbeatInterval: heartbeatInterval.Milliseconds()},
+		})
+
+		ws.readLoop(client) // listen for messages
 
-		ws.readLoop(c, c.Locals("userID").(string)) // listen for messages
+		// The connection is released once this handler returns, so the writer must be stopped first
+		ws.Connections.Unregister(client)
+		client.Close(websocket.CloseNormalClosure, "")
+		<-client.writerDone
+
+		if client.identified {
+			ws.removeConnectionStatus(client)
+		}
 	})
 }
 
-func (ws *WebsocketServer) readLoop(conn *websocket.Conn, userID string) {
+func (ws *WebsocketServer) readLoop(client *WebsocketClient) {
+	client.Conn.SetReadDeadline(time.Now().Add(identifyTimeout))
+
 	for {
-		_, _, err := conn.ReadMessage()
+		_, payload, err := client.Conn.ReadMessage()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				client.Close(CloseSessionTimedOut, "session timed out")
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Println(err)
+			}
+			return
+		}
+
+		var message inboundMessage
+		err = json.Unmarshal(payload, &message)
+		if err != nil {
+			client.Close(CloseDecodeError, "invalid payload")
+			return
+		}
+
+		err = ws.handleMessage(client, message)
 		if err != nil {
-			log.Println(err)
-			delete(ws.Connections, userID)
+			client.Close(closeCodeFor(err))
 			return
 		}
 	}
 }
+
+// SubscribeEvents starts delivering events from the event bus to this instance's connections.
+// It must be called once before the server starts accepting connections.
+func (ws *WebsocketServer) SubscribeEvents() {
+	ws.Events.Subscribe(ws.deliver)
+}
+
+// CloseSession disconnects the websocket opened with the given session, used when the session ends.
+// The session may be connected to any instance so the request goes through the event bus.
+func (ws *WebsocketServer) CloseSession(sessionID string) {
+	ws.publish(Event{
+		SessionID: sessionID,
+		CloseCode: CloseSessionEnded,
+	})
+}
+
+// CloseUserSessions disconnects every websocket of the user, used when all of their sessions are revoked.
+func (ws *WebsocketServer) CloseUserSessions(userID string) {
+	ws.publish(Event{
+		CloseUserID: userID,
+		CloseCode:   CloseSessionEnded,
+	})
+}